@@ -0,0 +1,32 @@
+package plugin
+
+import (
+	"sync"
+)
+
+// chunkBufPool recycles the scratch buffers used to decode SELECT/SUMS/
+// QUANTILES chunks off the wire, so a high-rate query doesn't allocate
+// megabytes/sec just to throw the buffer away the moment the caller is
+// done with the chunk.  Buffers are sized to the largest chunk that's
+// used them so far; callers that see unusually large chunks don't thrash
+// the pool on every Get.
+var chunkBufPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, 0)
+	},
+}
+
+func getChunkBuf(size uint32) []byte {
+	buf := chunkBufPool.Get().([]byte)
+	if cap(buf) < int(size) {
+		return make([]byte, size)
+	}
+	return buf[:size]
+}
+
+func putChunkBuf(buf []byte) {
+	if buf == nil {
+		return
+	}
+	chunkBufPool.Put(buf[:0])
+}
@@ -9,13 +9,17 @@ import (
 	"time"
 	"net/http"
 	"io"
+	"bufio"
 	"unsafe"
 	"strings"
+	"sync"
+	"math"
 	"crypto/tls"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
 	//"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	//"github.com/tgree/simple-tsdb/pkg/models"
@@ -37,6 +41,11 @@ const (
 	CT_SUM_POINTS           uint32 = 0x90305A39
 	CT_NOP                  uint32 = 0x22CF1296
 	CT_AUTHENTICATE         uint32 = 0x0995EBDA
+	CT_SUBSCRIBE_SERIES     uint32 = 0x4B8A7C15
+	CT_GET_RETENTION        uint32 = 0x3F710AD6
+	CT_SET_RETENTION        uint32 = 0x8D2C914F
+	CT_QUANTILE_POINTS      uint32 = 0xA61F7C2D
+	CT_INSERT_POINTS        uint32 = 0x5D9E2B47
 
 	DT_DATABASE             uint32 = 0x39385A4F   // <database>
 	DT_MEASUREMENT          uint32 = 0xDC1F48F3   // <measurement>
@@ -58,6 +67,13 @@ const (
 	DT_SUMS_CHUNK           uint32 = 0x53FC76FC   // <chunk_npoints> (uint16_t)
 	DT_USERNAME             uint32 = 0x6E39D1DE   // <username>
 	DT_PASSWORD             uint32 = 0x602E5B01   // <password>
+	DT_SINCE                uint32 = 0x1A6D4B93   // <since> (uint64_t)
+	DT_POINT                uint32 = 0x9C2E1FD7   // <time> (uint64_t), <value> (double)
+	DT_DURATION_NS          uint32 = 0x2E8F5C41   // <duration_ns> (uint64_t)
+	DT_SHARD_DURATION_NS    uint32 = 0x61B9D3AA   // <shard_duration_ns> (uint64_t)
+	DT_QUANTILE_LIST        uint32 = 0x9A1D63E5   // <q1>,<q2>,...
+	DT_COMPRESSION          uint32 = 0x4471FE2C   // <compression> (uint32_t)
+	DT_QUANTILES_CHUNK      uint32 = 0xF08E72A1   // <chunk_nbuckets> (uint16_t)
 
 	FT_BOOL uint8 = 1
 	FT_U32 uint8  = 2
@@ -78,6 +94,22 @@ var FT_MAP = map[uint8]string{
 	FT_I64:		"i64",
 }
 
+// fieldByteSize returns the on-wire element size of a field's type, so
+// multi-field chunk decoding can lay out each field's data array back to
+// back without consulting the server again.
+func fieldByteSize(field_type uint8) uint32 {
+	switch field_type {
+	case FT_BOOL:
+		return 1
+	case FT_U32, FT_F32, FT_I32:
+		return 4
+	case FT_U64, FT_F64, FT_I64:
+		return 8
+	default:
+		panic("Unknown field type!")
+	}
+}
+
 // Make sure Datasource implements required interfaces. This is important to do
 // since otherwise we will only get a not implemented error response from plugin in
 // runtime. In this example datasource instance implements backend.QueryDataHandler,
@@ -105,12 +137,18 @@ func NewDatasource(ctx context.Context, dsis backend.DataSourceInstanceSettings)
 		hostname: dm.Hostname,
 		username: dm.Username,
 		password: password,
+		database: dm.Database,
+		pool:     NewTSDBClientPool(dm.Hostname, dm.Username, password),
 	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/databases", d.handleDatabases) // Possibly unused
 	mux.HandleFunc("/measurements", d.handleMeasurements)
 	mux.HandleFunc("/series", d.handleSeries)
 	mux.HandleFunc("/fields", d.handleFields)
+	mux.HandleFunc("/pool/stats", d.handlePoolStats)
+	mux.HandleFunc("/remote_write", d.handleRemoteWrite)
+	mux.HandleFunc("/retention", d.handleRetention)
+	mux.HandleFunc("/delete", d.handleDelete)
 	d.resourceHandler = httpadapter.New(mux)
 	return d, nil
 }
@@ -122,13 +160,17 @@ type Datasource struct {
 	hostname		string
 	username		string
 	password		string
+	database		string
+	pool			*TSDBClientPool
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
 // created. As soon as datasource settings change detected by SDK old datasource instance will
 // be disposed and a new one will be created using NewSampleDatasource factory function.
 func (d *Datasource) Dispose() {
-	// Clean up datasource instance resources.
+	if d.pool != nil {
+		d.pool.Close()
+	}
 }
 
 type datasourceModel struct {
@@ -154,29 +196,49 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 		return nil, err
 	}
 
-	// Open a connection to the TSDB server.
-	password, exists := instanceSettings.DecryptedSecureJSONData["password"]
-	if !exists {
-		return nil, errors.New("Missing password")
-	}
-	tc, err := NewTSDBClient(dm.Hostname, dm.Username, password)
-	if err != nil {
-		return nil, err
+	if d.pool == nil {
+		return nil, errors.New("datasource has no connection pool")
 	}
-	defer tc.Close()
 
-	// loop over queries and execute them individually.
+	// Fan each query out across the connection pool concurrently, rather
+	// than serializing every series/field query on a single connection.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	wg.Add(len(req.Queries))
 	for _, q := range req.Queries {
-		res := d.query(ctx, req.PluginContext, tc, &dm, q)
+		q := q
+		go func() {
+			defer wg.Done()
+			res := d.queryPooled(ctx, req.PluginContext, &dm, q)
 
-		// save the response in a hashmap
-		// based on with RefID as identifier
-		response.Responses[q.RefID] = res
+			mu.Lock()
+			response.Responses[q.RefID] = res
+			mu.Unlock()
+		}()
 	}
+	wg.Wait()
 
 	return response, nil
 }
 
+// queryPooled checks out a connection from the pool, runs query(), and
+// returns the connection to the pool (or discards it if it came back
+// unhealthy).
+func (d *Datasource) queryPooled(ctx context.Context, pCtx backend.PluginContext, dm *datasourceModel, q backend.DataQuery) backend.DataResponse {
+	tc, err := d.pool.Get()
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+
+	res := d.query(ctx, pCtx, tc, dm, q)
+	if res.Error != nil {
+		d.pool.Discard(tc)
+	} else {
+		d.pool.Put(tc)
+	}
+	return res
+}
+
 // These fields are defind directly by BasicQuery in src/types.ts.
 // BasicQuery gets turned into JSON and then we unmarshal it into
 // this struct here, discarding any JSON fields we don't care about.
@@ -188,6 +250,10 @@ type queryModel struct {
 	Transform       string
 	Zoom            string
 	Alias           string
+	Live            bool
+	Quantile        float64
+	FillConstant        float64
+	MovingAverageWindow uint64
 
 	// From DataQuery.
 	IntervalMs      uint64
@@ -207,9 +273,9 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, tc *
 
 	var seriesList []string
 	if qm.Series == "All" {
-		seriesList, err = tc.ListActiveSeries(dm.Database, qm.Measurement, t0, t1)
+		seriesList, err = tc.ListActiveSeries(ctx, dm.Database, qm.Measurement, t0, t1)
 		if err != nil {
-			return backend.ErrDataResponse(backend.StatusBadRequest, "error from ListSeries")
+			return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
 		}
 	} else {
 		seriesList = strings.Split(qm.Series, " + ")
@@ -222,10 +288,20 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, tc *
 		if qm.Alias != "" {
 			alias = strings.Replace(qm.Alias, "$series", series, 1)
 		}
+
+		if qm.Live {
+			frame, err := d.queryLive(pCtx, dm.Database, qm.Measurement, series, qm.Field, alias, t1)
+			if err != nil {
+				return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+			}
+			response.Frames = append(response.Frames, frame)
+			continue
+		}
+
 		// Retrieve the point count for this measurement.
-		count_result, err := tc.CountPoints(dm.Database, qm.Measurement, series, t0, t1)
+		count_result, err := tc.CountPoints(ctx, dm.Database, qm.Measurement, series, t0, t1)
 		if err != nil {
-			return backend.ErrDataResponse(backend.StatusBadRequest, "error from COUNT")
+			return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
 		}
 		backend.Logger.Debug("Count Result", "count_result", count_result.String())
 		if count_result.npoints == 0 {
@@ -233,21 +309,34 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, tc *
 		}
 
 		var frame *data.Frame;
-		if (count_result.npoints >= 10000) {
+		if qm.Zoom == "LTTB" && count_result.npoints > uint64(query.MaxDataPoints) {
+			frame, err = d.queryLTTB(ctx, tc, dm.Database, qm.Measurement, series, qm.Field, alias,
+			                         t0, t1, count_result.npoints, query.MaxDataPoints)
+		} else if (count_result.npoints >= 10000) {
 			switch qm.Zoom {
 			case "Min/Max":
-				frame, err = d.queryMinMax(tc, dm.Database, qm.Measurement, series, qm.Field, alias,
+				frame, err = d.queryMinMax(ctx, tc, dm.Database, qm.Measurement, series, qm.Field, alias,
 				                           t0, t1, qm.IntervalMs * 1000000)
 
 			case "Mean":
-				frame, err = d.queryMean(tc, dm.Database, qm.Measurement, series, qm.Field, alias,
+				frame, err = d.queryMean(ctx, tc, dm.Database, qm.Measurement, series, qm.Field, alias,
 				                         t0, t1, qm.IntervalMs * 1000000)
+
+			case "Quantile":
+				frame, err = d.queryQuantile(ctx, tc, dm.Database, qm.Measurement, series, qm.Field, alias,
+				                             t0, t1, qm.IntervalMs * 1000000, qm.Quantile)
+
+			default:
+				// LTTB below its downsample threshold, or no Zoom
+				// selected at all: fall back to raw points instead
+				// of silently dropping the series.
+				frame, err = d.querySelect(ctx, tc, dm.Database, qm.Measurement, series, qm.Field, alias, t0, t1)
 			}
 		} else {
-			frame, err = d.querySelect(tc, dm.Database, qm.Measurement, series, qm.Field, alias, t0, t1)
+			frame, err = d.querySelect(ctx, tc, dm.Database, qm.Measurement, series, qm.Field, alias, t0, t1)
 		}
 		if err != nil {
-			return backend.ErrDataResponse(backend.StatusBadRequest, "error from DB query")
+			return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
 		}
 		if frame == nil {
 			continue
@@ -264,6 +353,18 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, tc *
 			TransformDerivative(frame, 60)
 		case "Derivative (hour)":
 			TransformDerivative(frame, 3600)
+		case "Rate":
+			TransformRate(frame)
+		case "Moving Average":
+			TransformMovingAverage(frame, int(qm.MovingAverageWindow))
+		case "Fill Null (Previous)":
+			TransformFillNull(frame, FillPrevious, 0)
+		case "Fill Null (Linear)":
+			TransformFillNull(frame, FillLinear, 0)
+		case "Fill Null (Zero)":
+			TransformFillNull(frame, FillZero, 0)
+		case "Fill Null (Constant)":
+			TransformFillNull(frame, FillConstant, qm.FillConstant)
 		}
 
 		response.Frames = append(response.Frames, frame)
@@ -272,15 +373,46 @@ func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, tc *
 	return response
 }
 
-func (d *Datasource) querySelect(tc *TSDBClient, database string, measurement string, series string, field string, alias string, t0 uint64, t1 uint64) (*data.Frame, error) {
+// queryLive builds an empty frame carrying only a Channel, which tells
+// Grafana to switch this panel into streaming mode and call RunStream
+// instead of polling QueryData.  The path encodes everything RunStream
+// needs to re-issue CT_SUBSCRIBE_SERIES.
+func (d *Datasource) queryLive(pCtx backend.PluginContext, database string, measurement string, series string, field string, alias string, since uint64) (*data.Frame, error) {
+	spm := streamPathModel{
+		Database:    database,
+		Measurement: measurement,
+		Series:      series,
+		Field:       field,
+		Since:       since,
+	}
+	path, err := json.Marshal(spm)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := data.NewFrame(alias,
+		data.NewField("time", nil, []time.Time{}),
+		data.NewField(alias, nil, []float64{}),
+	)
+	channel := live.Channel{
+		Scope:     live.ScopeDatasource,
+		Namespace: pCtx.DataSourceInstanceSettings.UID,
+		Path:      string(path),
+	}
+	frame.SetMeta(&data.FrameMeta{Channel: channel.String()})
+
+	return frame, nil
+}
+
+func (d *Datasource) querySelect(ctx context.Context, tc *TSDBClient, database string, measurement string, series string, field string, alias string, t0 uint64, t1 uint64) (*data.Frame, error) {
 	// Retrieve the schema for this measurement.
-	schema, err := tc.GetSchema(database, measurement)
+	schema, err := tc.GetSchema(ctx, database, measurement)
 	if err != nil {
 		return nil, err
 	}
 
 	// Generate the SELECT operation.
-	op, err := tc.NewSelectOp(schema, series, field, t0, t1, 0xFFFFFFFFFFFFFFFF)
+	op, err := tc.NewSelectOp(ctx, schema, series, []string{field}, t0, t1, 0xFFFFFFFFFFFFFFFF)
 	if err != nil {
 		return nil, err
 	}
@@ -290,7 +422,7 @@ func (d *Datasource) querySelect(tc *TSDBClient, database string, measurement st
 	ptrs := schema.MakePtrArray(field)
 	all_nil := true
 	for {
-		rxc, err := op.ReadChunk()
+		rxc, err := op.ReadChunk(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -303,10 +435,11 @@ func (d *Datasource) querySelect(tc *TSDBClient, database string, measurement st
 		}
 
 		var chunk_all_nil bool
-		ptrs, chunk_all_nil = rxc.AppendToArray(ptrs)
+		ptrs, chunk_all_nil = rxc.AppendField(field, ptrs)
 		if !chunk_all_nil {
 			all_nil = false
 		}
+		rxc.Release()
 	}
 	
 	// If no data, return empty frame.
@@ -322,9 +455,95 @@ func (d *Datasource) querySelect(tc *TSDBClient, database string, measurement st
 	), nil
 }
 
-func (d *Datasource) queryMean(tc *TSDBClient, database string, measurement string, series string, field string, alias string, t0 uint64, t1 uint64, window_ns uint64) (*data.Frame, error) {
+// lttbRawFetchCap is the point count above which we don't pull raw
+// points for LTTB - instead we pre-aggregate with SUMS down to roughly
+// this many buckets and run LTTB over the means, since streaming tens
+// of millions of raw points just to throw most of them away isn't worth
+// the bandwidth.
+const lttbRawFetchCap = 200000
+
+// queryLTTB fetches either the raw series or, if N is huge, a
+// SUMS/mean-windowed series, then runs LTTB in Go to downsample to
+// maxDataPoints while preserving peaks and valleys that naive
+// mean-bucketing would smooth away.
+func (d *Datasource) queryLTTB(ctx context.Context, tc *TSDBClient, database string, measurement string, series string, field string, alias string, t0 uint64, t1 uint64, npoints uint64, maxDataPoints int64) (*data.Frame, error) {
+	var src *data.Frame
+	var err error
+	if npoints > lttbRawFetchCap {
+		nbuckets := uint64(lttbRawFetchCap)
+		window_ns := (t1 - t0) / nbuckets
+		if window_ns == 0 {
+			window_ns = 1
+		}
+		src, err = d.queryMean(ctx, tc, database, measurement, series, field, alias, t0, t1, window_ns)
+	} else {
+		src, err = d.querySelect(ctx, tc, database, measurement, series, field, alias, t0, t1)
+	}
+	if err != nil || src == nil {
+		return src, err
+	}
+
+	timeField, _ := src.FieldByName("time")
+	valueField, _ := src.FieldByName(alias)
+	if timeField == nil || valueField == nil {
+		return src, nil
+	}
+
+	nrows := src.Rows()
+	pts := make([]lttbPoint, 0, nrows)
+	for i := 0; i < nrows; i++ {
+		v, ok := valueField.ConcreteAt(i)
+		if !ok {
+			continue
+		}
+		t := timeField.At(i).(time.Time)
+		pts = append(pts, lttbPoint{x: float64(t.UnixNano()), y: toFloat64(v)})
+	}
+
+	threshold := int(maxDataPoints)
+	sampled := lttbDownsample(pts, threshold)
+
+	timestamps := make([]time.Time, len(sampled))
+	values := make([]*float64, len(sampled))
+	for i, p := range sampled {
+		timestamps[i] = time.Unix(0, int64(p.x))
+		v := p.y
+		values[i] = &v
+	}
+
+	return data.NewFrame(
+		"response",
+		data.NewField("time", nil, timestamps),
+		data.NewField(alias, nil, values),
+	), nil
+}
+
+// toFloat64 converts any of the field types handled by this plugin's
+// type-switch pattern to a float64.
+func toFloat64(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case float32:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	case uint32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case uint8:
+		return float64(n)
+	default:
+		panic("Unhandled type!")
+	}
+}
+
+func (d *Datasource) queryMean(ctx context.Context, tc *TSDBClient, database string, measurement string, series string, field string, alias string, t0 uint64, t1 uint64, window_ns uint64) (*data.Frame, error) {
 	// Generate the SUMS operation.
-	op, err := tc.NewSumsOp(database, measurement, series, field, t0, t1, window_ns)
+	op, err := tc.NewSumsOp(ctx, database, measurement, series, field, t0, t1, window_ns)
 	if err != nil {
 		return nil, err
 	}
@@ -337,7 +556,7 @@ func (d *Datasource) queryMean(tc *TSDBClient, database string, measurement stri
 	chunk_base := uint64(0)
 	total_points := uint64(0)
 	for {
-		rxc, err := op.ReadChunk()
+		rxc, err := op.ReadChunk(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -365,6 +584,7 @@ func (d *Datasource) queryMean(tc *TSDBClient, database string, measurement stri
 		}
 
 		chunk_base += uint64(rxc.nbuckets)
+		rxc.Release()
 	}
 
 	// If no data, return empty frame.
@@ -380,15 +600,15 @@ func (d *Datasource) queryMean(tc *TSDBClient, database string, measurement stri
 	), nil
 }
 
-func (d *Datasource) queryMinMax(tc *TSDBClient, database string, measurement string, series string, field string, alias string, t0 uint64, t1 uint64, window_ns uint64) (*data.Frame, error) {
+func (d *Datasource) queryMinMax(ctx context.Context, tc *TSDBClient, database string, measurement string, series string, field string, alias string, t0 uint64, t1 uint64, window_ns uint64) (*data.Frame, error) {
 	// Retrieve the schema for this measurement.
-	schema, err := tc.GetSchema(database, measurement)
+	schema, err := tc.GetSchema(ctx, database, measurement)
 	if err != nil {
 		return nil, err
 	}
 
 	// Generate the SUMS operation.
-	op, err := tc.NewSumsOp(database, measurement, series, field, t0, t1, window_ns)
+	op, err := tc.NewSumsOp(ctx, database, measurement, series, field, t0, t1, window_ns)
 	if err != nil {
 		return nil, err
 	}
@@ -399,7 +619,7 @@ func (d *Datasource) queryMinMax(tc *TSDBClient, database string, measurement st
 	ptrs := schema.MakePtrArray(field)
 	have_non_nil := false
 	for {
-		rxc, err := op.ReadChunk()
+		rxc, err := op.ReadChunk(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -423,6 +643,60 @@ func (d *Datasource) queryMinMax(tc *TSDBClient, database string, measurement st
 				ptrs = rxc.AppendMin(ptrs, i)
 			}
 		}
+		rxc.Release()
+	}
+
+	if !have_non_nil {
+		return nil, nil
+	}
+
+	// Return the response.
+	return data.NewFrame(
+		"response",
+		data.NewField("time", nil, timestamps),
+		data.NewField(alias, nil, ptrs),
+	), nil
+}
+
+// quantileCompression bounds the t-digest sketch size the server keeps
+// per bucket; higher values trade bandwidth for accuracy.  100 is the
+// usual default for this algorithm and gives well under 1% error at the
+// quantiles we care about (p50/p95/p99).
+const quantileCompression = 100
+
+func (d *Datasource) queryQuantile(ctx context.Context, tc *TSDBClient, database string, measurement string, series string, field string, alias string, t0 uint64, t1 uint64, window_ns uint64, quantile float64) (*data.Frame, error) {
+	// Retrieve the schema for this measurement.
+	schema, err := tc.GetSchema(ctx, database, measurement)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate the QUANTILES operation.
+	op, err := tc.NewQuantilesOp(ctx, database, measurement, series, field, []float64{quantile}, quantileCompression, t0, t1, window_ns)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pull chunks of data from the server and append them to our running data.
+	timestamps := []time.Time{}
+	ptrs := schema.MakePtrArray(field)
+	have_non_nil := false
+	for {
+		rxc, err := op.ReadChunk(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if rxc == nil {
+			break
+		}
+
+		for i := uint16(0); i < rxc.nbuckets; i++ {
+			timestamps = append(timestamps, time.Unix(0, int64(rxc.timestamps[i])))
+			if len(rxc.centroids[i]) > 0 {
+				have_non_nil = true
+			}
+			ptrs = rxc.AppendQuantile(ptrs, i, quantile)
+		}
 	}
 
 	if !have_non_nil {
@@ -464,7 +738,7 @@ func (d *Datasource) CheckHealth(_ context.Context, req *backend.CheckHealthRequ
 	if err != nil {
 		return &backend.CheckHealthResult{
 			Status:  backend.HealthStatusError,
-			Message: "Unable to connect to TSDB server",
+			Message: fmt.Sprintf("Unable to connect to TSDB server: %v", err),
 		}, nil
 	}
 	defer tc.Close()
@@ -474,7 +748,7 @@ func (d *Datasource) CheckHealth(_ context.Context, req *backend.CheckHealthRequ
 	if err != nil {
 		return &backend.CheckHealthResult{
 			Status:  backend.HealthStatusError,
-			Message: "TSDB server didn't handle NOP command",
+			Message: fmt.Sprintf("TSDB server didn't handle NOP command: %v", err),
 		}, nil
 	}
 
@@ -505,14 +779,14 @@ func (d *Datasource) handleDatabases(rw http.ResponseWriter, req *http.Request)
 	rsp := databasesResponse{}
 	rsp.Databases, err = tc.ListDatabases()
 	if err != nil {
-		panic("Error listing databases!")
+		http.Error(rw, err.Error(), http.StatusBadGateway)
 		return
 	}
 	backend.Logger.Debug("Databases", "databases", rsp.Databases)
 
 	bytes, err := json.Marshal(rsp)
 	if err != nil {
-		panic("Error marshalling response!")
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -543,14 +817,14 @@ func (d *Datasource) handleMeasurements(rw http.ResponseWriter, req *http.Reques
 	rsp := measurementsResponse{}
 	rsp.Measurements, err = tc.ListMeasurements(database)
 	if err != nil {
-		panic("Error listing measurements!")
+		http.Error(rw, err.Error(), http.StatusBadGateway)
 		return
 	}
 	backend.Logger.Debug("Measurements", "measurements", rsp.Measurements)
 
 	bytes, err := json.Marshal(rsp)
 	if err != nil {
-		panic("Error marshalling response!")
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -584,16 +858,16 @@ func (d *Datasource) handleSeries(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	rsp := seriesResponse{}
-	rsp.Series, err = tc.ListSeries(database, measurement)
+	rsp.Series, err = tc.ListSeries(req.Context(), database, measurement)
 	if err != nil {
-		panic("Error listing series!")
+		http.Error(rw, err.Error(), http.StatusBadGateway)
 		return
 	}
 	backend.Logger.Debug("Series", "series", rsp.Series)
 
 	bytes, err := json.Marshal(rsp)
 	if err != nil {
-		panic("Error marshalling response!")
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -626,8 +900,9 @@ func (d *Datasource) handleFields(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	schema, err := tc.GetSchema(database, measurement)
+	schema, err := tc.GetSchema(req.Context(), database, measurement)
 	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadGateway)
 		return
 	}
 
@@ -635,7 +910,8 @@ func (d *Datasource) handleFields(rw http.ResponseWriter, req *http.Request) {
 	rsp.Fields = schema.fields
 	bytes, err := json.Marshal(rsp)
 	if err != nil {
-		panic("Error marshalling response!")
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	rw.Header().Add("Content-Type", "application/json")
@@ -648,6 +924,7 @@ func (d *Datasource) handleFields(rw http.ResponseWriter, req *http.Request) {
 
 type TSDBClient struct {
 	conn	*tls.Conn
+	rd	*bufio.Reader
 }
 
 func NewTSDBClient(hostname string, username string, password string) (*TSDBClient, error) {
@@ -655,9 +932,7 @@ func NewTSDBClient(hostname string, username string, password string) (*TSDBClie
 	if err != nil {
 		return nil, err
 	}
-	client := &TSDBClient{
-		conn: conn,
-	}
+	client := newTSDBClientFromConn(conn)
 	err = client.Authenticate(username, password)
 	if err != nil {
 		client.Close()
@@ -666,6 +941,17 @@ func NewTSDBClient(hostname string, username string, password string) (*TSDBClie
 	return client, nil
 }
 
+// newTSDBClientFromConn wraps an already-established connection (real or,
+// in tests, an in-memory TLS pipe) in a TSDBClient.  conn reads go through
+// a bufio.Reader so that the many small ReadU32/ReadU16/ReadU64 header
+// reads on a chunk don't each cost a syscall.
+func newTSDBClientFromConn(conn *tls.Conn) *TSDBClient {
+	return &TSDBClient{
+		conn: conn,
+		rd:   bufio.NewReader(conn),
+	}
+}
+
 func (self *TSDBClient) Close() {
 	self.conn.Close()
 }
@@ -723,7 +1009,7 @@ func (self *TSDBClient) WriteStringToken(token uint32, s string) error {
 func (self *TSDBClient) ReadU16() (uint16, error) {
 	var v uint16
 
-	err := binary.Read(self.conn, binary.LittleEndian, &v)
+	err := binary.Read(self.rd, binary.LittleEndian, &v)
 	if err != nil {
 		return 0, err
 	}
@@ -734,7 +1020,7 @@ func (self *TSDBClient) ReadU16() (uint16, error) {
 func (self *TSDBClient) ReadU32() (uint32, error) {
 	var v uint32
 
-	err := binary.Read(self.conn, binary.LittleEndian, &v)
+	err := binary.Read(self.rd, binary.LittleEndian, &v)
 	if err != nil {
 		return 0, err
 	}
@@ -745,7 +1031,7 @@ func (self *TSDBClient) ReadU32() (uint32, error) {
 func (self *TSDBClient) ReadU64() (uint64, error) {
 	var v uint64
 
-	err := binary.Read(self.conn, binary.LittleEndian, &v)
+	err := binary.Read(self.rd, binary.LittleEndian, &v)
 	if err != nil {
 		return 0, err
 	}
@@ -756,7 +1042,7 @@ func (self *TSDBClient) ReadU64() (uint64, error) {
 func (self *TSDBClient) ReadI32() (int32, error) {
 	var v int32
 
-	err := binary.Read(self.conn, binary.LittleEndian, &v)
+	err := binary.Read(self.rd, binary.LittleEndian, &v)
 	if err != nil {
 		return 0, err
 	}
@@ -766,12 +1052,12 @@ func (self *TSDBClient) ReadI32() (int32, error) {
 
 func (self *TSDBClient) ReadString(size uint16) (string, error) {
 	buf := make([]byte, size)
-	n, err := io.ReadFull(self.conn, buf)
+	n, err := io.ReadFull(self.rd, buf)
 	if err != nil {
 		return "", err
 	}
 	if n != int(size) {
-		panic("Unexpected read length!")
+		return "", fmt.Errorf("TSDB protocol error: short read (expected %v bytes, got %v)", size, n)
 	}
 	return string(buf), nil
 }
@@ -800,15 +1086,14 @@ func (self *TSDBClient) Authenticate(username string, password string) error {
 		return err
 	}
 	if dt != DT_STATUS_CODE {
-		panic("Expected DT_STATUS_CODE.")
+		return &ProtocolError{Expected: DT_STATUS_CODE, Got: dt, Op: "CT_AUTHENTICATE"}
 	}
 	sc, err := self.ReadI32()
 	if err != nil {
 		return err
 	}
 	if sc != 0 {
-		backend.Logger.Debug("Status", "status", sc)
-		panic("Unexpected AUTHENTICATE status")
+		return &TSDBError{Status: sc, Op: "CT_AUTHENTICATE"}
 	}
 
 	return nil
@@ -830,15 +1115,14 @@ func (self *TSDBClient) NOP() error {
 		return err
 	}
 	if dt != DT_STATUS_CODE {
-		panic("Expected DT_STATUS_CODE.")
+		return &ProtocolError{Expected: DT_STATUS_CODE, Got: dt, Op: "CT_NOP"}
 	}
 	sc, err := self.ReadI32()
 	if err != nil {
 		return err
 	}
 	if sc != 0 {
-		backend.Logger.Debug("Status", "status", sc)
-		panic("Unexpected NOP status")
+		return &TSDBError{Status: sc, Op: "CT_NOP"}
 	}
 
 	return nil
@@ -867,15 +1151,14 @@ func (self *TSDBClient) ListDatabases() ([]string, error) {
 				return nil, err
 			}
 			if sc != 0 {
-				backend.Logger.Debug("Status", "status", sc)
-				panic("Unexpected status")
+				return nil, &TSDBError{Status: sc, Op: "CT_LIST_DATABASES"}
 			}
 
 			return databases, nil
 		}
 
 		if dt != DT_DATABASE {
-			panic("Expected DT_DATABASE")
+			return nil, &ProtocolError{Expected: DT_DATABASE, Got: dt, Op: "CT_LIST_DATABASES"}
 		}
 		size, err := self.ReadU16()
 		if err != nil {
@@ -919,15 +1202,14 @@ func (self *TSDBClient) ListMeasurements(database string) ([]string, error) {
 				return nil, err
 			}
 			if sc != 0 {
-				backend.Logger.Debug("Status", "status", sc)
-				panic("Unexpected status")
+				return nil, &TSDBError{Status: sc, Op: "CT_LIST_MEASUREMENTS"}
 			}
 
 			return measurements, nil
 		}
 
 		if dt != DT_MEASUREMENT {
-			panic("Expected DT_MEASUREMENT")
+			return nil, &ProtocolError{Expected: DT_MEASUREMENT, Got: dt, Op: "CT_LIST_MEASUREMENTS"}
 		}
 		size, err := self.ReadU16()
 		if err != nil {
@@ -943,8 +1225,14 @@ func (self *TSDBClient) ListMeasurements(database string) ([]string, error) {
 	}
 }
 
-func (self *TSDBClient) ListSeries(database string, measurement string) ([]string, error) {
-	err := self.WriteU32(CT_LIST_SERIES)
+func (self *TSDBClient) ListSeries(ctx context.Context, database string, measurement string) ([]string, error) {
+	reset, err := self.withDeadline(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reset()
+
+	err = self.WriteU32(CT_LIST_SERIES)
 	if err != nil {
 		return nil, err
 	}
@@ -976,15 +1264,14 @@ func (self *TSDBClient) ListSeries(database string, measurement string) ([]strin
 				return nil, err
 			}
 			if sc != 0 {
-				backend.Logger.Debug("Status", "status", sc)
-				panic("Unexpected status")
+				return nil, &TSDBError{Status: sc, Op: "CT_LIST_SERIES"}
 			}
 
 			return series, nil
 		}
 
 		if dt != DT_SERIES {
-			panic("Expected DT_SERIES")
+			return nil, &ProtocolError{Expected: DT_SERIES, Got: dt, Op: "CT_LIST_SERIES"}
 		}
 		size, err := self.ReadU16()
 		if err != nil {
@@ -1000,8 +1287,14 @@ func (self *TSDBClient) ListSeries(database string, measurement string) ([]strin
 	}
 }
 
-func (self *TSDBClient) ListActiveSeries(database string, measurement string, t0 uint64, t1 uint64) ([]string, error) {
-	err := self.WriteU32(CT_ACTIVE_SERIES)
+func (self *TSDBClient) ListActiveSeries(ctx context.Context, database string, measurement string, t0 uint64, t1 uint64) ([]string, error) {
+	reset, err := self.withDeadline(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reset()
+
+	err = self.WriteU32(CT_ACTIVE_SERIES)
 	if err != nil {
 		return nil, err
 	}
@@ -1043,15 +1336,14 @@ func (self *TSDBClient) ListActiveSeries(database string, measurement string, t0
 				return nil, err
 			}
 			if sc != 0 {
-				backend.Logger.Debug("Status", "status", sc)
-				panic("Unexpected status")
+				return nil, &TSDBError{Status: sc, Op: "CT_ACTIVE_SERIES"}
 			}
 
 			return series, nil
 		}
 
 		if dt != DT_SERIES {
-			panic("Expected DT_SERIES")
+			return nil, &ProtocolError{Expected: DT_SERIES, Got: dt, Op: "CT_ACTIVE_SERIES"}
 		}
 		size, err := self.ReadU16()
 		if err != nil {
@@ -1067,8 +1359,14 @@ func (self *TSDBClient) ListActiveSeries(database string, measurement string, t0
 	}
 }
 
-func (self *TSDBClient) GetSchema(database string, measurement string) (*Schema, error) {
-	err := self.WriteU32(CT_GET_SCHEMA)
+func (self *TSDBClient) GetSchema(ctx context.Context, database string, measurement string) (*Schema, error) {
+	reset, err := self.withDeadline(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reset()
+
+	err = self.WriteU32(CT_GET_SCHEMA)
 	if err != nil {
 		return nil, err
 	}
@@ -1104,14 +1402,13 @@ func (self *TSDBClient) GetSchema(database string, measurement string) (*Schema,
 				return nil, err
 			}
 			if sc != 0 {
-				backend.Logger.Debug("Status", "status", sc)
-				panic("Unexpected status")
+				return nil, &TSDBError{Status: sc, Op: "CT_GET_SCHEMA"}
 			}
 
 			return &schema, nil
 		}
 		if dt != DT_FIELD_TYPE {
-			panic("Expected DT_FIELD_TYPE")
+			return nil, &ProtocolError{Expected: DT_FIELD_TYPE, Got: dt, Op: "CT_GET_SCHEMA"}
 		}
 		field_type, err := self.ReadU32()
 		if err != nil {
@@ -1123,7 +1420,7 @@ func (self *TSDBClient) GetSchema(database string, measurement string) (*Schema,
 			return nil, err
 		}
 		if dt != DT_FIELD_NAME {
-			panic("Expected DT_FIELD_NAME")
+			return nil, &ProtocolError{Expected: DT_FIELD_NAME, Got: dt, Op: "CT_GET_SCHEMA"}
 		}
 		size, err := self.ReadU16()
 		if err != nil {
@@ -1152,8 +1449,14 @@ func (self *CountResult) String() string {
 	return fmt.Sprintf("<time_first: %v, time_last: %v, npoints: %v>", self.time_first, self.time_last, self.npoints)
 }
 
-func (self *TSDBClient) CountPoints(database string, measurement string, series string, t0 uint64, t1 uint64) (*CountResult, error) {
-	err := self.WriteU32(CT_COUNT_POINTS)
+func (self *TSDBClient) CountPoints(ctx context.Context, database string, measurement string, series string, t0 uint64, t1 uint64) (*CountResult, error) {
+	reset, err := self.withDeadline(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reset()
+
+	err = self.WriteU32(CT_COUNT_POINTS)
 	if err != nil {
 		return nil, err
 	}
@@ -1197,11 +1500,10 @@ func (self *TSDBClient) CountPoints(database string, measurement string, series
 		if err != nil {
 			return nil, err
 		}
-		backend.Logger.Debug("Status", "status", sc)
-		panic("Unexpected status")
+		return nil, &TSDBError{Status: sc, Op: "CT_COUNT_POINTS"}
 	}
 	if dt != DT_TIME_FIRST {
-		panic("Expected DT_TIME_FIRST")
+		return nil, &ProtocolError{Expected: DT_TIME_FIRST, Got: dt, Op: "CT_COUNT_POINTS"}
 	}
 	time_first, err := self.ReadU64()
 	if err != nil {
@@ -1213,7 +1515,7 @@ func (self *TSDBClient) CountPoints(database string, measurement string, series
 		return nil, err
 	}
 	if dt != DT_TIME_LAST {
-		panic("Expected DT_TIME_LAST")
+		return nil, &ProtocolError{Expected: DT_TIME_LAST, Got: dt, Op: "CT_COUNT_POINTS"}
 	}
 	time_last, err := self.ReadU64()
 	if err != nil {
@@ -1225,7 +1527,7 @@ func (self *TSDBClient) CountPoints(database string, measurement string, series
 		return nil, err
 	}
 	if dt != DT_NPOINTS {
-		panic("Expected DT_NPOINTS")
+		return nil, &ProtocolError{Expected: DT_NPOINTS, Got: dt, Op: "CT_COUNT_POINTS"}
 	}
 	npoints, err := self.ReadU64()
 	if err != nil {
@@ -1237,15 +1539,14 @@ func (self *TSDBClient) CountPoints(database string, measurement string, series
 		return nil, err
 	}
 	if dt != DT_STATUS_CODE {
-		panic("Expected DT_STATUS_CODE")
+		return nil, &ProtocolError{Expected: DT_STATUS_CODE, Got: dt, Op: "CT_COUNT_POINTS"}
 	}
 	sc, err := self.ReadI32()
 	if err != nil {
 		return nil, err
 	}
 	if sc != 0 {
-		backend.Logger.Debug("Status", "status", sc)
-		panic("Unexpected status")
+		return nil, &TSDBError{Status: sc, Op: "CT_COUNT_POINTS"}
 	}
 
 	return &CountResult{
@@ -1255,6 +1556,124 @@ func (self *TSDBClient) CountPoints(database string, measurement string, series
 	}, nil
 }
 
+// SubscribedPoint is one (time, value) sample delivered by a Subscribe
+// stream.
+type SubscribedPoint struct {
+	time  uint64
+	value float64
+}
+
+// Subscribe issues CT_SUBSCRIBE_SERIES and starts a background goroutine
+// that reads DT_POINT tokens off the connection and pushes them onto the
+// returned channel as they arrive.  The connection is dedicated to this
+// subscription for its lifetime - it can't be reused for anything else.
+// The points channel is closed, and an error (if any) sent on errc, when
+// the server ends the stream or the connection fails.  ctx also guards
+// the send onto points: if the caller stops reading (e.g. the panel was
+// unsubscribed) before the stream ends on its own, the goroutine exits on
+// ctx.Done() instead of blocking forever on a send nobody will receive.
+func (self *TSDBClient) Subscribe(ctx context.Context, database string, measurement string, series string, field string, since uint64) (<-chan SubscribedPoint, <-chan error, error) {
+	err := self.WriteU32(CT_SUBSCRIBE_SERIES)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = self.WriteStringToken(DT_DATABASE, database)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = self.WriteStringToken(DT_MEASUREMENT, measurement)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = self.WriteStringToken(DT_SERIES, series)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = self.WriteStringToken(DT_FIELD_LIST, field)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = self.WriteU64Token(DT_SINCE, since)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = self.WriteU32(DT_END)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dt, err := self.ReadU32()
+	if err != nil {
+		return nil, nil, err
+	}
+	if dt == DT_STATUS_CODE {
+		sc, err := self.ReadI32()
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, &TSDBError{Status: sc, Op: "CT_SUBSCRIBE_SERIES"}
+	}
+	if dt != DT_END {
+		return nil, nil, &ProtocolError{Expected: DT_END, Got: dt, Op: "CT_SUBSCRIBE_SERIES"}
+	}
+
+	points := make(chan SubscribedPoint)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(points)
+		for {
+			dt, err := self.ReadU32()
+			if err != nil {
+				errc <- err
+				return
+			}
+
+			if dt == DT_STATUS_CODE {
+				sc, err := self.ReadI32()
+				if err != nil {
+					errc <- err
+					return
+				}
+				if sc != 0 {
+					errc <- &TSDBError{Status: sc, Op: "CT_SUBSCRIBE_SERIES"}
+				}
+				return
+			}
+
+			if dt != DT_POINT {
+				errc <- &ProtocolError{Expected: DT_POINT, Got: dt, Op: "CT_SUBSCRIBE_SERIES"}
+				return
+			}
+
+			t, err := self.ReadU64()
+			if err != nil {
+				errc <- err
+				return
+			}
+			rawv, err := self.ReadU64()
+			if err != nil {
+				errc <- err
+				return
+			}
+
+			select {
+			case points <- SubscribedPoint{time: t, value: math.Float64frombits(rawv)}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return points, errc, nil
+}
+
 type SchemaField struct {
 	name		string
 	field_type	uint8
@@ -1335,25 +1754,35 @@ type SelectOp struct {
 	client		*TSDBClient
 	schema		*Schema
 	series		string
-	field		string
+	fields		[]string
 	t0		uint64
 	t1		uint64
 	limit		uint64
 	last_token	uint32
 }
 
-func (self *TSDBClient) NewSelectOp(schema *Schema, series string, field string, t0 uint64, t1 uint64, limit uint64) (*SelectOp, error) {
+// NewSelectOp issues CT_SELECT_POINTS_LIMIT for one or more fields of a
+// series in a single round trip.  The server streams back chunks whose
+// bitmaps and data arrays are laid out per field, in the same order as
+// fields, behind one shared timestamp column - see RXChunk.AppendField.
+func (self *TSDBClient) NewSelectOp(ctx context.Context, schema *Schema, series string, fields []string, t0 uint64, t1 uint64, limit uint64) (*SelectOp, error) {
 	op := SelectOp{
 		client:		self,
 		schema:		schema,
 		series:		series,
-		field:		field,
+		fields:		fields,
 		t0:		t0,
 		t1:		t1,
 		limit:		limit,
 	}
 
-	err := self.WriteU32(CT_SELECT_POINTS_LIMIT)
+	reset, err := self.withDeadline(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reset()
+
+	err = self.WriteU32(CT_SELECT_POINTS_LIMIT)
 	if err != nil {
 		return nil, err
 	}
@@ -1373,7 +1802,7 @@ func (self *TSDBClient) NewSelectOp(schema *Schema, series string, field string,
 		return nil, err
 	}
 
-	err = self.WriteStringToken(DT_FIELD_LIST, field)
+	err = self.WriteStringToken(DT_FIELD_LIST, strings.Join(fields, ","))
 	if err != nil {
 		return nil, err
 	}
@@ -1407,48 +1836,51 @@ func (self *TSDBClient) NewSelectOp(schema *Schema, series string, field string,
 		if err != nil {
 			return nil, err
 		}
-		backend.Logger.Debug("Status", "status", sc)
-		panic("Unexpected status")
+		return nil, &TSDBError{Status: sc, Op: "CT_SELECT_POINTS_LIMIT"}
 	}
 
 	return &op, nil
 }
 
 type RXChunk struct {
-	op		*SelectOp
-	npoints		uint32
-	bitmap_offset	uint32
-	data_offset     uint32
-	data		[]byte
-	timestamps	[]uint64
-	bitmap          []uint64
+	op			*SelectOp
+	npoints			uint32
+	bitmap_offset		uint32
+	data			[]byte
+	timestamps		[]uint64
+	field_bitmaps		map[string][]uint64
+	field_data_offsets	map[string]uint32
 }
 
-func (self *SelectOp) ReadChunk() (*RXChunk, error) {
+func (self *SelectOp) ReadChunk(ctx context.Context) (*RXChunk, error) {
+	reset, err := self.client.withDeadline(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reset()
+
 	if self.last_token == DT_END {
 		dt, err := self.client.ReadU32()
 		if err != nil {
 			return nil, err
 		}
 		if dt != DT_STATUS_CODE {
-			backend.Logger.Debug("Garbage token", "garbage_token", dt)
-			panic("Expected DT_STATUS_CODE")
+			return nil, &ProtocolError{Expected: DT_STATUS_CODE, Got: dt, Op: "CT_SELECT_POINTS_LIMIT"}
 		}
-		
+
 		sc, err := self.client.ReadI32()
 		if err != nil {
 			return nil, err
 		}
 		if sc != 0 {
-			backend.Logger.Debug("Status", "status", sc)
-			panic("Unexpected status")
+			return nil, &TSDBError{Status: sc, Op: "CT_SELECT_POINTS_LIMIT"}
 		}
 
 		return nil, nil
 	}
 
 	if self.last_token != DT_CHUNK {
-		panic("Expected DT_CHUNK or DT_END")
+		return nil, &ProtocolError{Expected: DT_CHUNK, Got: self.last_token, Op: "CT_SELECT_POINTS_LIMIT"}
 	}
 	npoints, err := self.client.ReadU32()
 	if err != nil {
@@ -1462,14 +1894,13 @@ func (self *SelectOp) ReadChunk() (*RXChunk, error) {
 	if err != nil {
 		return nil, err
 	}
-	data := make([]byte, data_len)
-	n, err := io.ReadFull(self.client.conn, data)
+	data := getChunkBuf(data_len)
+	n, err := io.ReadFull(self.client.rd, data)
 	if err != nil {
 		return nil, err
 	}
 	if n != int(data_len) {
-		backend.Logger.Debug("Bad read length", "expected len", data_len, "got len", n)
-		panic("Unexpected read length!")
+		return nil, fmt.Errorf("TSDB protocol error: short chunk read (expected %v bytes, got %v) during CT_SELECT_POINTS_LIMIT", data_len, n)
 	}
 
 	self.last_token, err = self.client.ReadU32()
@@ -1480,44 +1911,83 @@ func (self *SelectOp) ReadChunk() (*RXChunk, error) {
 	return NewChunk(self, npoints, bitmap_offset, data)
 }
 
+// Iterate pulls chunks until the server signals end-of-stream or an
+// error occurs, calling fn with each one and releasing its scratch
+// buffer back to the pool afterwards regardless of whether fn (or the
+// read itself) failed.
+func (self *SelectOp) Iterate(ctx context.Context, fn func(*RXChunk) error) error {
+	for {
+		rxc, err := self.ReadChunk(ctx)
+		if err != nil {
+			return err
+		}
+		if rxc == nil {
+			return nil
+		}
+
+		err = fn(rxc)
+		rxc.Release()
+		if err != nil {
+			return err
+		}
+	}
+}
+
 func NewChunk(op *SelectOp, npoints uint32, bitmap_offset uint32, data []byte) (*RXChunk, error) {
 	p := unsafe.Pointer(&data[0])
 	timestamps := unsafe.Slice((*uint64)(p), npoints)
 
-	offset := npoints * 8
 	bitmap_nslots := ((bitmap_offset + npoints + 63) / 64)
-	p = unsafe.Pointer(&data[offset])
-	bitmap := unsafe.Slice((*uint64)(p), bitmap_nslots)
+	offset := npoints * 8
+
+	field_bitmaps := make(map[string][]uint64, len(op.fields))
+	field_data_offsets := make(map[string]uint32, len(op.fields))
+	for _, field := range op.fields {
+		p = unsafe.Pointer(&data[offset])
+		field_bitmaps[field] = unsafe.Slice((*uint64)(p), bitmap_nslots)
+		offset += bitmap_nslots * 8
+
+		field_data_offsets[field] = offset
+		offset += npoints * fieldByteSize(op.schema.fields_map[field].field_type)
+	}
 
-	data_offset := offset + bitmap_nslots * 8
 	return &RXChunk{
-		op:		op,
-		npoints:	npoints,
-		bitmap_offset:	bitmap_offset,
-		data_offset:    data_offset,
-		data:		data,
-		timestamps:     timestamps,
-		bitmap:         bitmap,
+		op:			op,
+		npoints:		npoints,
+		bitmap_offset:		bitmap_offset,
+		data:			data,
+		timestamps:		timestamps,
+		field_bitmaps:		field_bitmaps,
+		field_data_offsets:	field_data_offsets,
 	}, nil
 }
 
-func (self *RXChunk) IsNull(i uint32) bool {
+func (self *RXChunk) IsNull(field string, i uint32) bool {
+	bitmap := self.field_bitmaps[field]
 	bitmap_index := (self.bitmap_offset + i) / 64
 	shift := (self.bitmap_offset + i) % 64
-	return (self.bitmap[bitmap_index] & (1 << shift)) == 0
-}
-
-func (self *RXChunk) AppendToArray(ptrs interface{}) (interface{}, bool) {
-	p := unsafe.Pointer(&self.data[self.data_offset])
+	return (bitmap[bitmap_index] & (1 << shift)) == 0
+}
+
+// AppendField decodes one field's data array out of the chunk and
+// appends it - nils standing in for missing points - onto ptrs, which
+// must be the *<type> slice matching fieldName's schema type (see
+// Schema.MakePtrArray).  Every non-null value is copied off the wire
+// buffer rather than aliased into it, so the returned pointers stay
+// valid after the chunk's Release() puts that buffer back in the pool
+// for reuse by a concurrent query.
+func (self *RXChunk) AppendField(fieldName string, ptrs interface{}) (interface{}, bool) {
+	p := unsafe.Pointer(&self.data[self.field_data_offsets[fieldName]])
 	all_nil := true
 	switch ptrs.(type) {
 	case []*float64:
 		vf64 := unsafe.Slice((*float64)(p), self.npoints)
 		for i := uint32(0); i < self.npoints; i++ {
-			if self.IsNull(i) {
+			if self.IsNull(fieldName, i) {
 				ptrs = append(ptrs.([]*float64), nil)
 			} else {
-				ptrs = append(ptrs.([]*float64), &vf64[i])
+				v := vf64[i]
+				ptrs = append(ptrs.([]*float64), &v)
 				all_nil = false
 			}
 		}
@@ -1525,10 +1995,11 @@ func (self *RXChunk) AppendToArray(ptrs interface{}) (interface{}, bool) {
 	case []*float32:
 		vf32 := unsafe.Slice((*float32)(p), self.npoints)
 		for i := uint32(0); i < self.npoints; i++ {
-			if self.IsNull(i) {
+			if self.IsNull(fieldName, i) {
 				ptrs = append(ptrs.([]*float32), nil)
 			} else {
-				ptrs = append(ptrs.([]*float32), &vf32[i])
+				v := vf32[i]
+				ptrs = append(ptrs.([]*float32), &v)
 				all_nil = false
 			}
 		}
@@ -1536,10 +2007,11 @@ func (self *RXChunk) AppendToArray(ptrs interface{}) (interface{}, bool) {
 	case []*uint64:
 		vu64 := unsafe.Slice((*uint64)(p), self.npoints)
 		for i := uint32(0); i < self.npoints; i++ {
-			if self.IsNull(i) {
+			if self.IsNull(fieldName, i) {
 				ptrs = append(ptrs.([]*uint64), nil)
 			} else {
-				ptrs = append(ptrs.([]*uint64), &vu64[i])
+				v := vu64[i]
+				ptrs = append(ptrs.([]*uint64), &v)
 				all_nil = false
 			}
 		}
@@ -1547,10 +2019,11 @@ func (self *RXChunk) AppendToArray(ptrs interface{}) (interface{}, bool) {
 	case []*uint32:
 		vu32 := unsafe.Slice((*uint32)(p), self.npoints)
 		for i := uint32(0); i < self.npoints; i++ {
-			if self.IsNull(i) {
+			if self.IsNull(fieldName, i) {
 				ptrs = append(ptrs.([]*uint32), nil)
 			} else {
-				ptrs = append(ptrs.([]*uint32), &vu32[i])
+				v := vu32[i]
+				ptrs = append(ptrs.([]*uint32), &v)
 				all_nil = false
 			}
 		}
@@ -1558,10 +2031,11 @@ func (self *RXChunk) AppendToArray(ptrs interface{}) (interface{}, bool) {
 	case []*int64:
 		vi64 := unsafe.Slice((*int64)(p), self.npoints)
 		for i := uint32(0); i < self.npoints; i++ {
-			if self.IsNull(i) {
+			if self.IsNull(fieldName, i) {
 				ptrs = append(ptrs.([]*int64), nil)
 			} else {
-				ptrs = append(ptrs.([]*int64), &vi64[i])
+				v := vi64[i]
+				ptrs = append(ptrs.([]*int64), &v)
 				all_nil = false
 			}
 		}
@@ -1569,10 +2043,11 @@ func (self *RXChunk) AppendToArray(ptrs interface{}) (interface{}, bool) {
 	case []*int32:
 		vi32 := unsafe.Slice((*int32)(p), self.npoints)
 		for i := uint32(0); i < self.npoints; i++ {
-			if self.IsNull(i) {
+			if self.IsNull(fieldName, i) {
 				ptrs = append(ptrs.([]*int32), nil)
 			} else {
-				ptrs = append(ptrs.([]*int32), &vi32[i])
+				v := vi32[i]
+				ptrs = append(ptrs.([]*int32), &v)
 				all_nil = false
 			}
 		}
@@ -1580,10 +2055,11 @@ func (self *RXChunk) AppendToArray(ptrs interface{}) (interface{}, bool) {
 	case []*uint8:
 		vu8 := unsafe.Slice((*uint8)(p), self.npoints)
 		for i := uint32(0); i < self.npoints; i++ {
-			if self.IsNull(i) {
+			if self.IsNull(fieldName, i) {
 				ptrs = append(ptrs.([]*uint8), nil)
 			} else {
-				ptrs = append(ptrs.([]*uint8), &vu8[i])
+				v := vu8[i]
+				ptrs = append(ptrs.([]*uint8), &v)
 				all_nil = false
 			}
 		}
@@ -1600,6 +2076,14 @@ func (self *RXChunk) String() string {
 	return fmt.Sprintf("<npoints %v, bitmap_offset %v>", self.npoints, self.bitmap_offset)
 }
 
+// Release returns the chunk's scratch buffer to the pool.  Once called,
+// the chunk's timestamps/field data - all aliased directly into that
+// buffer via unsafe.Slice - must not be touched again.
+func (self *RXChunk) Release() {
+	putChunkBuf(self.data)
+	self.data = nil
+}
+
 type SumsOp struct {
 	client		*TSDBClient
 	database	string
@@ -1612,7 +2096,7 @@ type SumsOp struct {
 	last_token	uint32
 }
 
-func (self *TSDBClient) NewSumsOp(database string, measurement string, series string, field string, t0 uint64, t1 uint64, window_ns uint64) (*SumsOp, error) {
+func (self *TSDBClient) NewSumsOp(ctx context.Context, database string, measurement string, series string, field string, t0 uint64, t1 uint64, window_ns uint64) (*SumsOp, error) {
 	op := SumsOp{
 		client:		self,
 		database:	database,
@@ -1624,7 +2108,13 @@ func (self *TSDBClient) NewSumsOp(database string, measurement string, series st
 		window_ns:	window_ns,
 	}
 
-	err := self.WriteU32(CT_SUM_POINTS)
+	reset, err := self.withDeadline(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reset()
+
+	err = self.WriteU32(CT_SUM_POINTS)
 	if err != nil {
 		return nil, err
 	}
@@ -1678,8 +2168,7 @@ func (self *TSDBClient) NewSumsOp(database string, measurement string, series st
 		if err != nil {
 			return nil, err
 		}
-		backend.Logger.Debug("Status", "status", sc)
-		panic("Unexpected status")
+		return nil, &TSDBError{Status: sc, Op: "CT_SUM_POINTS"}
 	}
 
 	return &op, nil
@@ -1696,46 +2185,49 @@ type RXSumsChunk struct {
 	npoints		[]uint64
 }
 
-func (self *SumsOp) ReadChunk() (*RXSumsChunk, error) {
+func (self *SumsOp) ReadChunk(ctx context.Context) (*RXSumsChunk, error) {
+	reset, err := self.client.withDeadline(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reset()
+
 	if self.last_token == DT_END {
 		dt, err := self.client.ReadU32()
 		if err != nil {
 			return nil, err
 		}
 		if dt != DT_STATUS_CODE {
-			backend.Logger.Debug("Garbage token", "garbage_token", dt)
-			panic("Expected DT_STATUS_CODE")
+			return nil, &ProtocolError{Expected: DT_STATUS_CODE, Got: dt, Op: "CT_SUM_POINTS"}
 		}
-		
+
 		sc, err := self.client.ReadI32()
 		if err != nil {
 			return nil, err
 		}
 		if sc != 0 {
-			backend.Logger.Debug("Status", "status", sc)
-			panic("Unexpected status")
+			return nil, &TSDBError{Status: sc, Op: "CT_SUM_POINTS"}
 		}
 
 		return nil, nil
 	}
 
 	if self.last_token != DT_SUMS_CHUNK {
-		panic("Expected DT_SUMS_CHUNK or DT_END")
+		return nil, &ProtocolError{Expected: DT_SUMS_CHUNK, Got: self.last_token, Op: "CT_SUM_POINTS"}
 	}
 	chunk_npoints, err := self.client.ReadU16()
 	if err != nil {
 		return nil, err
 	}
 
-	data_len := chunk_npoints * (8 + 1 * 32)	// 1 since only 1 field
-	data := make([]byte, data_len)
-	n, err := io.ReadFull(self.client.conn, data)
+	data_len := uint32(chunk_npoints) * (8 + 1 * 32)	// 1 since only 1 field
+	data := getChunkBuf(data_len)
+	n, err := io.ReadFull(self.client.rd, data)
 	if err != nil {
 		return nil, err
 	}
 	if n != int(data_len) {
-		backend.Logger.Debug("Bad read length", "expected len", data_len, "got len", n)
-		panic("Unexpected read length!")
+		return nil, fmt.Errorf("TSDB protocol error: short chunk read (expected %v bytes, got %v) during CT_SUM_POINTS", data_len, n)
 	}
 
 	self.last_token, err = self.client.ReadU32()
@@ -1835,70 +2327,90 @@ func (self *RXSumsChunk) AppendMean(dst any, bucket uint16) any {
 	}
 }
 
+// AppendMax copies bucket's max value off the wire buffer and appends it
+// to dst - a copy, not an alias, so the value stays valid after the
+// chunk's Release() recycles the underlying buffer.
 func (self *RXSumsChunk) AppendMax(dst any, bucket uint16) any {
 	switch d := dst.(type) {
 	case []*uint8:
-		v := unsafe.Slice((*uint8)(self.maxs), self.nbuckets * 8)
-		return append(d, &v[bucket * 8])
+		s := unsafe.Slice((*uint8)(self.maxs), self.nbuckets * 8)
+		v := s[bucket * 8]
+		return append(d, &v)
 
 	case []*uint32:
-		v := unsafe.Slice((*uint32)(self.maxs), self.nbuckets * 2)
-		return append(d, &v[bucket * 2])
+		s := unsafe.Slice((*uint32)(self.maxs), self.nbuckets * 2)
+		v := s[bucket * 2]
+		return append(d, &v)
 
 	case []*uint64:
-		v := unsafe.Slice((*uint64)(self.maxs), self.nbuckets)
-		return append(d, &v[bucket])
+		s := unsafe.Slice((*uint64)(self.maxs), self.nbuckets)
+		v := s[bucket]
+		return append(d, &v)
 
 	case []*float32:
-		v := unsafe.Slice((*float32)(self.maxs), self.nbuckets * 2)
-		return append(d, &v[bucket * 2])
+		s := unsafe.Slice((*float32)(self.maxs), self.nbuckets * 2)
+		v := s[bucket * 2]
+		return append(d, &v)
 
 	case []*float64:
-		v := unsafe.Slice((*float64)(self.maxs), self.nbuckets)
-		return append(d, &v[bucket])
+		s := unsafe.Slice((*float64)(self.maxs), self.nbuckets)
+		v := s[bucket]
+		return append(d, &v)
 
 	case []*int32:
-		v := unsafe.Slice((*int32)(self.maxs), self.nbuckets * 2)
-		return append(d, &v[bucket * 2])
+		s := unsafe.Slice((*int32)(self.maxs), self.nbuckets * 2)
+		v := s[bucket * 2]
+		return append(d, &v)
 
 	case []*int64:
-		v := unsafe.Slice((*int64)(self.maxs), self.nbuckets)
-		return append(d, &v[bucket])
+		s := unsafe.Slice((*int64)(self.maxs), self.nbuckets)
+		v := s[bucket]
+		return append(d, &v)
 
 	default:
 		panic("Unhandled type!")
 	}
 }
 
+// AppendMin copies bucket's min value off the wire buffer and appends it
+// to dst - a copy, not an alias, so the value stays valid after the
+// chunk's Release() recycles the underlying buffer.
 func (self *RXSumsChunk) AppendMin(dst any, bucket uint16) any {
 	switch d := dst.(type) {
 	case []*uint8:
-		v := unsafe.Slice((*uint8)(self.mins), self.nbuckets * 8)
-		return append(d, &v[bucket * 8])
+		s := unsafe.Slice((*uint8)(self.mins), self.nbuckets * 8)
+		v := s[bucket * 8]
+		return append(d, &v)
 
 	case []*uint32:
-		v := unsafe.Slice((*uint32)(self.mins), self.nbuckets * 2)
-		return append(d, &v[bucket * 2])
+		s := unsafe.Slice((*uint32)(self.mins), self.nbuckets * 2)
+		v := s[bucket * 2]
+		return append(d, &v)
 
 	case []*uint64:
-		v := unsafe.Slice((*uint64)(self.mins), self.nbuckets)
-		return append(d, &v[bucket])
+		s := unsafe.Slice((*uint64)(self.mins), self.nbuckets)
+		v := s[bucket]
+		return append(d, &v)
 
 	case []*float32:
-		v := unsafe.Slice((*float32)(self.mins), self.nbuckets * 2)
-		return append(d, &v[bucket * 2])
+		s := unsafe.Slice((*float32)(self.mins), self.nbuckets * 2)
+		v := s[bucket * 2]
+		return append(d, &v)
 
 	case []*float64:
-		v := unsafe.Slice((*float64)(self.mins), self.nbuckets)
-		return append(d, &v[bucket])
+		s := unsafe.Slice((*float64)(self.mins), self.nbuckets)
+		v := s[bucket]
+		return append(d, &v)
 
 	case []*int32:
-		v := unsafe.Slice((*int32)(self.mins), self.nbuckets * 2)
-		return append(d, &v[bucket * 2])
+		s := unsafe.Slice((*int32)(self.mins), self.nbuckets * 2)
+		v := s[bucket * 2]
+		return append(d, &v)
 
 	case []*int64:
-		v := unsafe.Slice((*int64)(self.mins), self.nbuckets)
-		return append(d, &v[bucket])
+		s := unsafe.Slice((*int64)(self.mins), self.nbuckets)
+		v := s[bucket]
+		return append(d, &v)
 
 	default:
 		panic("Unhandled type!")
@@ -1940,6 +2452,15 @@ func NewSumsChunk(op *SumsOp, chunk_npoints uint16, data []byte) (*RXSumsChunk,
 	}, nil
 }
 
+// Release returns the chunk's scratch buffer to the pool.  Once called,
+// the chunk's timestamps/sums/mins/maxs/npoints - all aliased directly
+// into that buffer via unsafe.Slice/unsafe.Pointer - must not be touched
+// again.
+func (self *RXSumsChunk) Release() {
+	putChunkBuf(self.data)
+	self.data = nil
+}
+
 func TransformTare(frame *data.Frame) {
 	nrows := frame.Rows()
 	if nrows == 0 {
@@ -2094,3 +2615,255 @@ func TransformDerivative(frame *data.Frame, periodSecs float64) {
 		frame.Fields[j] = newField
 	}
 }
+
+// TransformRate is like TransformDerivative(frame, 1) except it treats a
+// negative delta as a counter reset - the same convention Prometheus'
+// rate() uses - and drops that point instead of reporting a misleading
+// negative rate.
+func TransformRate(frame *data.Frame) {
+	nrows := frame.Rows()
+	if nrows == 0 {
+		return
+	}
+
+	timeField, _ := frame.FieldByName("time")
+	if timeField == nil {
+		return
+	}
+
+	for j, field := range frame.Fields {
+		if field.Name == "time" {
+			continue
+		}
+
+		newField := data.NewFieldFromFieldType(data.FieldTypeNullableFloat64, nrows)
+		newField.Name = field.Name
+
+		for i := nrows - 1; i > 0; i-- {
+			newField.Set(i, nil)
+
+			f1, ok := field.ConcreteAt(i)
+			if !ok {
+				continue
+			}
+			f0, ok := field.ConcreteAt(i - 1)
+			if !ok {
+				continue
+			}
+
+			delta := toFloat64(f1) - toFloat64(f0)
+			if delta < 0 {
+				continue
+			}
+
+			t1 := timeField.At(i).(time.Time)
+			t0 := timeField.At(i - 1).(time.Time)
+			dt := float64(t1.UnixNano() - t0.UnixNano()) / float64(1000000000.)
+
+			newField.SetConcrete(i, delta / dt)
+		}
+		newField.Set(0, nil)
+
+		frame.Fields[j] = newField
+	}
+}
+
+// TransformMovingAverage replaces each field with its trailing mean over
+// the last window points, ignoring (rather than zeroing) nulls within
+// the window.  A point with no concrete samples in its window is left
+// null.
+func TransformMovingAverage(frame *data.Frame, window int) {
+	nrows := frame.Rows()
+	if nrows == 0 || window <= 0 {
+		return
+	}
+
+	for j, field := range frame.Fields {
+		if field.Name == "time" {
+			continue
+		}
+
+		newField := data.NewFieldFromFieldType(data.FieldTypeNullableFloat64, nrows)
+		newField.Name = field.Name
+
+		for i := 0; i < nrows; i++ {
+			lo := i - window + 1
+			if lo < 0 {
+				lo = 0
+			}
+
+			sum := 0.0
+			count := 0
+			for k := lo; k <= i; k++ {
+				v, ok := field.ConcreteAt(k)
+				if !ok {
+					continue
+				}
+				sum += toFloat64(v)
+				count++
+			}
+
+			if count == 0 {
+				newField.Set(i, nil)
+			} else {
+				newField.SetConcrete(i, sum / float64(count))
+			}
+		}
+
+		frame.Fields[j] = newField
+	}
+}
+
+// FillMode selects how TransformFillNull replaces a null sample.
+type FillMode int
+
+const (
+	// FillPrevious carries the last concrete value forward.
+	FillPrevious FillMode = iota
+	// FillLinear interpolates between the two nearest concrete values
+	// using the time field's UnixNano, so gaps of uneven cadence
+	// interpolate correctly.
+	FillLinear
+	// FillZero fills with the zero value.
+	FillZero
+	// FillConstant fills with the constant passed to TransformFillNull.
+	FillConstant
+)
+
+// TransformFillNull replaces null samples in every non-time field
+// according to mode.  Fields made up entirely of nulls are left alone -
+// there's no concrete value to anchor a fill type or interpolation on.
+func TransformFillNull(frame *data.Frame, mode FillMode, constant float64) {
+	nrows := frame.Rows()
+	if nrows == 0 {
+		return
+	}
+
+	timeField, _ := frame.FieldByName("time")
+
+	for _, field := range frame.Fields {
+		if field.Name == "time" {
+			continue
+		}
+
+		sample := any(nil)
+		for i := 0; i < nrows; i++ {
+			if v, ok := field.ConcreteAt(i); ok {
+				sample = v
+				break
+			}
+		}
+		if sample == nil {
+			continue
+		}
+
+		switch mode {
+		case FillZero:
+			fillNullConstant(field, sample, 0)
+		case FillConstant:
+			fillNullConstant(field, sample, constant)
+		case FillPrevious:
+			fillNullPrevious(field, nrows)
+		case FillLinear:
+			if timeField != nil {
+				fillNullLinear(field, timeField, nrows)
+			}
+		}
+	}
+}
+
+func fillNullConstant(field *data.Field, sample any, constant float64) {
+	nrows := field.Len()
+	for i := 0; i < nrows; i++ {
+		if !field.NilAt(i) {
+			continue
+		}
+
+		switch sample.(type) {
+		case float64:
+			field.SetConcrete(i, constant)
+		case float32:
+			field.SetConcrete(i, float32(constant))
+		case uint64:
+			field.SetConcrete(i, uint64(constant))
+		case uint32:
+			field.SetConcrete(i, uint32(constant))
+		case int64:
+			field.SetConcrete(i, int64(constant))
+		case int32:
+			field.SetConcrete(i, int32(constant))
+		case uint8:
+			field.SetConcrete(i, uint8(constant))
+		default:
+			panic("Bad type!")
+		}
+	}
+}
+
+func fillNullPrevious(field *data.Field, nrows int) {
+	last := any(nil)
+	for i := 0; i < nrows; i++ {
+		if v, ok := field.ConcreteAt(i); ok {
+			last = v
+			continue
+		}
+		if last != nil {
+			field.SetConcrete(i, last)
+		}
+	}
+}
+
+func fillNullLinear(field *data.Field, timeField *data.Field, nrows int) {
+	concreteAt := make([]int, 0, nrows)
+	for i := 0; i < nrows; i++ {
+		if !field.NilAt(i) {
+			concreteAt = append(concreteAt, i)
+		}
+	}
+	if len(concreteAt) == 0 {
+		return
+	}
+
+	ci := 0
+	for i := 0; i < nrows; i++ {
+		if !field.NilAt(i) {
+			continue
+		}
+		for ci < len(concreteAt) && concreteAt[ci] < i {
+			ci++
+		}
+		if ci == 0 || ci == len(concreteAt) {
+			// No concrete value on one side - can't interpolate, leave null.
+			continue
+		}
+
+		leftIdx, rightIdx := concreteAt[ci-1], concreteAt[ci]
+		left, _ := field.ConcreteAt(leftIdx)
+		right, _ := field.ConcreteAt(rightIdx)
+
+		t := timeField.At(i).(time.Time).UnixNano()
+		t0 := timeField.At(leftIdx).(time.Time).UnixNano()
+		t1 := timeField.At(rightIdx).(time.Time).UnixNano()
+		frac := float64(t - t0) / float64(t1 - t0)
+		interp := toFloat64(left) + frac * (toFloat64(right) - toFloat64(left))
+
+		switch left.(type) {
+		case float64:
+			field.SetConcrete(i, interp)
+		case float32:
+			field.SetConcrete(i, float32(interp))
+		case uint64:
+			field.SetConcrete(i, uint64(interp))
+		case uint32:
+			field.SetConcrete(i, uint32(interp))
+		case int64:
+			field.SetConcrete(i, int64(interp))
+		case int32:
+			field.SetConcrete(i, int32(interp))
+		case uint8:
+			field.SetConcrete(i, uint8(interp))
+		default:
+			panic("Bad type!")
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package plugin
+
+import (
+	"context"
+	"time"
+)
+
+// withDeadline arranges for the client's connection to be interrupted
+// when ctx is done, so a slow or dead server can't wedge a query
+// forever.  If ctx carries a deadline, it's applied to the connection
+// up front; either way, a watcher goroutine forces the deadline the
+// moment ctx is cancelled.  Callers must invoke the returned func when
+// the command completes to stop the watcher and clear the deadline.
+func (self *TSDBClient) withDeadline(ctx context.Context) (func(), error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := self.conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			self.conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		self.conn.SetDeadline(time.Time{})
+	}, nil
+}
@@ -0,0 +1,101 @@
+package plugin
+
+import (
+	"fmt"
+)
+
+// statusText mirrors the errno space returned by the server in a
+// DT_STATUS_CODE token.  Keep this table in sync with the server's
+// status code definitions; it exists purely to make TSDBError messages
+// readable instead of a bare integer.
+var statusText = map[int32]string{
+	0:  "success",
+	1:  "no such database",
+	2:  "database already exists",
+	3:  "no such series",
+	4:  "no such field",
+	5:  "measurement already exists",
+	6:  "permission denied",
+	7:  "invalid credentials",
+	8:  "invalid time range",
+	9:  "invalid argument",
+	10: "corrupt data",
+	11: "series already exists",
+	12: "no such measurement",
+}
+
+func statusString(sc int32) string {
+	if s, ok := statusText[sc]; ok {
+		return s
+	}
+	return "unknown error"
+}
+
+// dtNames maps DT_* wire tokens back to their symbolic names, so that
+// protocol errors read like "expected DT_STATUS_CODE, got DT_DATABASE"
+// instead of a pair of hex constants.
+var dtNames = map[uint32]string{
+	DT_DATABASE:        "DT_DATABASE",
+	DT_MEASUREMENT:     "DT_MEASUREMENT",
+	DT_SERIES:          "DT_SERIES",
+	DT_TYPED_FIELDS:    "DT_TYPED_FIELDS",
+	DT_FIELD_LIST:      "DT_FIELD_LIST",
+	DT_CHUNK:           "DT_CHUNK",
+	DT_TIME_FIRST:      "DT_TIME_FIRST",
+	DT_TIME_LAST:       "DT_TIME_LAST",
+	DT_NLIMIT:          "DT_NLIMIT",
+	DT_NLAST:           "DT_NLAST",
+	DT_END:             "DT_END",
+	DT_STATUS_CODE:     "DT_STATUS_CODE",
+	DT_FIELD_TYPE:      "DT_FIELD_TYPE",
+	DT_FIELD_NAME:      "DT_FIELD_NAME",
+	DT_READY_FOR_CHUNK: "DT_READY_FOR_CHUNK",
+	DT_NPOINTS:         "DT_NPOINTS",
+	DT_WINDOW_NS:       "DT_WINDOW_NS",
+	DT_SUMS_CHUNK:      "DT_SUMS_CHUNK",
+	DT_USERNAME:        "DT_USERNAME",
+	DT_PASSWORD:        "DT_PASSWORD",
+	DT_SINCE:           "DT_SINCE",
+	DT_POINT:           "DT_POINT",
+	DT_DURATION_NS:     "DT_DURATION_NS",
+	DT_SHARD_DURATION_NS: "DT_SHARD_DURATION_NS",
+	DT_QUANTILE_LIST:   "DT_QUANTILE_LIST",
+	DT_COMPRESSION:     "DT_COMPRESSION",
+	DT_QUANTILES_CHUNK: "DT_QUANTILES_CHUNK",
+}
+
+func dtName(dt uint32) string {
+	if s, ok := dtNames[dt]; ok {
+		return s
+	}
+	return fmt.Sprintf("0x%08X", dt)
+}
+
+// TSDBError is returned whenever the server answers a command with a
+// nonzero DT_STATUS_CODE.  Op records which command was in flight (e.g.
+// "CT_GET_SCHEMA") so callers - and, ultimately, Grafana's health check
+// and query error surfaces - can report something actionable instead of
+// a generic failure.
+type TSDBError struct {
+	Status int32
+	Op     string
+}
+
+func (e *TSDBError) Error() string {
+	return fmt.Sprintf("TSDB error: %s (%d) during %s", statusString(e.Status), e.Status, e.Op)
+}
+
+// ProtocolError is returned when the server sends a DT_* token other
+// than the one the client's state machine was expecting.  This usually
+// means a client/server version skew rather than a user-facing data
+// problem.
+type ProtocolError struct {
+	Expected uint32
+	Got      uint32
+	Op       string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("TSDB protocol error: expected %s, got %s during %s",
+		dtName(e.Expected), dtName(e.Got), e.Op)
+}
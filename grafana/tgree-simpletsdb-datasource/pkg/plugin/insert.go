@@ -0,0 +1,497 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// insertDefaultBatchSize caps how many rows InsertOp buffers before an
+// automatic Flush, independent of FlushEvery.
+const insertDefaultBatchSize = 4096
+
+// InsertOp is a streaming writer for CT_INSERT_POINTS.  Unlike WritePoint
+// (one round trip per sample), it buffers rows and emits them as
+// DT_CHUNK-shaped chunks matching the layout RXChunk decodes - so bulk
+// loads and Grafana annotation writes can share the same wire format and
+// the same connection type as queries.
+type InsertOp struct {
+	client     *TSDBClient
+	schema     *Schema
+	series     string
+	fields     []string
+	batchSize  int
+	flushEvery time.Duration
+	maxDataLen uint32
+	timestamps []uint64
+	values     map[string][]interface{}
+	last_flush time.Time
+}
+
+// NewInsertOp issues CT_INSERT_POINTS for series, declaring the set of
+// fields that every AppendRow/AppendFrame call will supply values for, in
+// that order.  The server answers with DT_READY_FOR_CHUNK, advertising
+// the largest data_len it's willing to accept per chunk; Flush splits
+// oversized batches to honor it.
+func (self *TSDBClient) NewInsertOp(ctx context.Context, schema *Schema, series string, fields []string, batchSize int, flushEvery time.Duration) (*InsertOp, error) {
+	if batchSize <= 0 {
+		batchSize = insertDefaultBatchSize
+	}
+
+	op := InsertOp{
+		client:     self,
+		schema:     schema,
+		series:     series,
+		fields:     fields,
+		batchSize:  batchSize,
+		flushEvery: flushEvery,
+		values:     make(map[string][]interface{}, len(fields)),
+		last_flush: time.Now(),
+	}
+
+	reset, err := self.withDeadline(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reset()
+
+	err = self.WriteU32(CT_INSERT_POINTS)
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.WriteStringToken(DT_DATABASE, schema.database)
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.WriteStringToken(DT_MEASUREMENT, schema.measurement)
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.WriteStringToken(DT_SERIES, series)
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.WriteStringToken(DT_FIELD_LIST, strings.Join(fields, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.WriteU32(DT_END)
+	if err != nil {
+		return nil, err
+	}
+
+	dt, err := self.ReadU32()
+	if err != nil {
+		return nil, err
+	}
+	if dt == DT_STATUS_CODE {
+		sc, err := self.ReadI32()
+		if err != nil {
+			return nil, err
+		}
+		return nil, &TSDBError{Status: sc, Op: "CT_INSERT_POINTS"}
+	}
+	if dt != DT_READY_FOR_CHUNK {
+		return nil, &ProtocolError{Expected: DT_READY_FOR_CHUNK, Got: dt, Op: "CT_INSERT_POINTS"}
+	}
+
+	op.maxDataLen, err = self.ReadU32()
+	if err != nil {
+		return nil, err
+	}
+
+	return &op, nil
+}
+
+// AppendRow buffers one row; values must have one entry per field passed
+// to NewInsertOp, in that order, with a nil entry standing in for a
+// missing value.  Each value is coerced to the Go type writeInsertField
+// expects for its schema field via the same coerceFieldValue AppendFrame
+// uses, so a float64 for an FT_U32 field or a plain int literal works
+// the same from either entry point.  It triggers a Flush once the batch
+// size or flush deadline is reached.
+func (self *InsertOp) AppendRow(ctx context.Context, ts uint64, values []interface{}) error {
+	if len(values) != len(self.fields) {
+		return fmt.Errorf("values has %v entries, want %v (one per field passed to NewInsertOp)", len(values), len(self.fields))
+	}
+
+	coerced := make([]interface{}, len(values))
+	for i, field := range self.fields {
+		v, err := coerceFieldValue(self.schema.fields_map[field].field_type, values[i])
+		if err != nil {
+			return fmt.Errorf("field %q: %w", field, err)
+		}
+		coerced[i] = v
+	}
+
+	self.timestamps = append(self.timestamps, ts)
+	for i, field := range self.fields {
+		self.values[field] = append(self.values[field], coerced[i])
+	}
+
+	if len(self.timestamps) >= self.batchSize {
+		return self.Flush(ctx)
+	}
+	if self.flushEvery > 0 && time.Since(self.last_flush) >= self.flushEvery {
+		return self.Flush(ctx)
+	}
+	return nil
+}
+
+// AppendFrame buffers every row of frame, which must carry a "time"
+// field plus one field per entry in self.fields (by name).  Column
+// values are handed to AppendRow as-is, which coerces each one to the
+// Go type writeInsertField expects for its schema field - so frames
+// built with Grafana's usual nullable/pointer field kinds (e.g.
+// *float64) work the same as plain ones.
+func (self *InsertOp) AppendFrame(ctx context.Context, frame *data.Frame) error {
+	timeField, ok := frame.FieldByName("time")
+	if !ok {
+		return fmt.Errorf("frame has no \"time\" field")
+	}
+
+	fieldCols := make([]*data.Field, len(self.fields))
+	for i, field := range self.fields {
+		col, ok := frame.FieldByName(field)
+		if !ok {
+			return fmt.Errorf("frame has no %q field", field)
+		}
+		fieldCols[i] = col
+	}
+
+	for row := 0; row < timeField.Len(); row++ {
+		ts := uint64(timeField.At(row).(time.Time).UnixNano())
+		values := make([]interface{}, len(self.fields))
+		for i, col := range fieldCols {
+			values[i] = col.At(row)
+		}
+		if err := self.AppendRow(ctx, ts, values); err != nil {
+			return fmt.Errorf("row %v: %w", row, err)
+		}
+	}
+
+	return nil
+}
+
+// coerceFieldValue converts one value read off a data.Field into the Go
+// type writeInsertField expects for field_type, returning a nil
+// interface{} (a missing value) for both an untyped nil and a typed nil
+// pointer - data.Field.At returns the latter for a null entry in a
+// nullable column.
+func coerceFieldValue(field_type uint8, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+	v = rv.Interface()
+
+	switch field_type {
+	case FT_BOOL:
+		if b, ok := v.(bool); ok {
+			if b {
+				return uint8(1), nil
+			}
+			return uint8(0), nil
+		}
+		if rv.CanInt() || rv.CanUint() {
+			if asInt64(rv) != 0 {
+				return uint8(1), nil
+			}
+			return uint8(0), nil
+		}
+	case FT_U32:
+		if rv.CanInt() || rv.CanUint() || rv.CanFloat() {
+			return uint32(asInt64(rv)), nil
+		}
+	case FT_U64:
+		if rv.CanInt() || rv.CanUint() || rv.CanFloat() {
+			return uint64(asInt64(rv)), nil
+		}
+	case FT_F32:
+		if rv.CanFloat() || rv.CanInt() || rv.CanUint() {
+			return float32(asFloat64(rv)), nil
+		}
+	case FT_F64:
+		if rv.CanFloat() || rv.CanInt() || rv.CanUint() {
+			return asFloat64(rv), nil
+		}
+	case FT_I32:
+		if rv.CanInt() || rv.CanUint() || rv.CanFloat() {
+			return int32(asInt64(rv)), nil
+		}
+	case FT_I64:
+		if rv.CanInt() || rv.CanUint() || rv.CanFloat() {
+			return int64(asInt64(rv)), nil
+		}
+	}
+
+	return nil, fmt.Errorf("value %v (%T) does not match field type", v, v)
+}
+
+// asInt64 widens any numeric reflect.Value to int64.
+func asInt64(rv reflect.Value) int64 {
+	switch {
+	case rv.CanInt():
+		return rv.Int()
+	case rv.CanUint():
+		return int64(rv.Uint())
+	default:
+		return int64(rv.Float())
+	}
+}
+
+// asFloat64 widens any numeric reflect.Value to float64.
+func asFloat64(rv reflect.Value) float64 {
+	switch {
+	case rv.CanFloat():
+		return rv.Float()
+	case rv.CanInt():
+		return float64(rv.Int())
+	default:
+		return float64(rv.Uint())
+	}
+}
+
+// insertRowBytes estimates the marginal data_len cost of one buffered
+// row, leaving out the per-field null bitmaps - only a first estimate;
+// Flush trims the row count until a whole chunk fits under maxDataLen.
+func (self *InsertOp) insertRowBytes() uint32 {
+	n := uint32(8) // timestamp
+	for _, field := range self.fields {
+		n += fieldByteSize(self.schema.fields_map[field].field_type)
+	}
+	return n
+}
+
+// Flush emits everything buffered so far as one or more DT_CHUNK writes,
+// each sized to respect maxDataLen, then clears the buffer.  It's a
+// no-op if nothing is buffered.
+func (self *InsertOp) Flush(ctx context.Context) error {
+	if len(self.timestamps) == 0 {
+		self.last_flush = time.Now()
+		return nil
+	}
+
+	rowBytes := self.insertRowBytes()
+	maxRows := len(self.timestamps)
+	if rowBytes > 0 && self.maxDataLen > 0 {
+		if n := int(self.maxDataLen / rowBytes); n < maxRows {
+			if n < 1 {
+				n = 1
+			}
+			maxRows = n
+		}
+
+		// Account for the null bitmaps insertRowBytes leaves out.
+		chunkLen := func(rows int) uint32 {
+			n := uint32(rows)
+			l := n * 8
+			for _, field := range self.fields {
+				l += (n+63)/64*8 + n*fieldByteSize(self.schema.fields_map[field].field_type)
+			}
+			return l
+		}
+		for maxRows > 1 && chunkLen(maxRows) > self.maxDataLen {
+			maxRows--
+		}
+	}
+
+	for start := 0; start < len(self.timestamps); start += maxRows {
+		end := start + maxRows
+		if end > len(self.timestamps) {
+			end = len(self.timestamps)
+		}
+		if err := self.writeChunk(ctx, start, end); err != nil {
+			return err
+		}
+	}
+
+	self.timestamps = nil
+	self.values = make(map[string][]interface{}, len(self.fields))
+	self.last_flush = time.Now()
+	return nil
+}
+
+// writeChunk encodes timestamps/values[start:end] into the RXChunk wire
+// layout (npoints, bitmap_offset=0, data_len, then timestamps, then per
+// field a null bitmap followed by packed data) and sends it as one
+// DT_CHUNK.
+func (self *InsertOp) writeChunk(ctx context.Context, start int, end int) error {
+	npoints := uint32(end - start)
+	bitmap_nslots := (npoints + 63) / 64
+
+	data_len := npoints * 8
+	for _, field := range self.fields {
+		data_len += bitmap_nslots*8 + npoints*fieldByteSize(self.schema.fields_map[field].field_type)
+	}
+
+	buf := getChunkBuf(data_len)
+	defer putChunkBuf(buf)
+
+	p := unsafe.Pointer(&buf[0])
+	ts_dst := unsafe.Slice((*uint64)(p), npoints)
+	copy(ts_dst, self.timestamps[start:end])
+	offset := npoints * 8
+
+	for _, field := range self.fields {
+		p = unsafe.Pointer(&buf[offset])
+		bitmap := unsafe.Slice((*uint64)(p), bitmap_nslots)
+		for i := range bitmap {
+			bitmap[i] = 0
+		}
+		offset += bitmap_nslots * 8
+
+		vals := self.values[field][start:end]
+		p = unsafe.Pointer(&buf[offset])
+		writeInsertField(self.schema.fields_map[field].field_type, p, bitmap, vals)
+		offset += npoints * fieldByteSize(self.schema.fields_map[field].field_type)
+	}
+
+	reset, err := self.client.withDeadline(ctx)
+	if err != nil {
+		return err
+	}
+	defer reset()
+
+	if err := self.client.WriteU32(DT_CHUNK); err != nil {
+		return err
+	}
+	if err := self.client.WriteU32(npoints); err != nil {
+		return err
+	}
+	if err := self.client.WriteU32(0); err != nil { // bitmap_offset
+		return err
+	}
+	if err := self.client.WriteU32(data_len); err != nil {
+		return err
+	}
+	_, err = self.client.conn.Write(buf)
+	return err
+}
+
+// writeInsertField packs vals (one entry per point, nil meaning missing)
+// into dst and sets the matching bit in bitmap for every non-nil entry.
+func writeInsertField(field_type uint8, dst unsafe.Pointer, bitmap []uint64, vals []interface{}) {
+	setBit := func(i int) {
+		bitmap[i/64] |= 1 << (uint(i) % 64)
+	}
+
+	switch field_type {
+	case FT_BOOL:
+		d := unsafe.Slice((*uint8)(dst), len(vals))
+		for i, v := range vals {
+			if v == nil {
+				continue
+			}
+			d[i] = v.(uint8)
+			setBit(i)
+		}
+	case FT_U32:
+		d := unsafe.Slice((*uint32)(dst), len(vals))
+		for i, v := range vals {
+			if v == nil {
+				continue
+			}
+			d[i] = v.(uint32)
+			setBit(i)
+		}
+	case FT_U64:
+		d := unsafe.Slice((*uint64)(dst), len(vals))
+		for i, v := range vals {
+			if v == nil {
+				continue
+			}
+			d[i] = v.(uint64)
+			setBit(i)
+		}
+	case FT_F32:
+		d := unsafe.Slice((*float32)(dst), len(vals))
+		for i, v := range vals {
+			if v == nil {
+				continue
+			}
+			d[i] = v.(float32)
+			setBit(i)
+		}
+	case FT_F64:
+		d := unsafe.Slice((*float64)(dst), len(vals))
+		for i, v := range vals {
+			if v == nil {
+				continue
+			}
+			d[i] = v.(float64)
+			setBit(i)
+		}
+	case FT_I32:
+		d := unsafe.Slice((*int32)(dst), len(vals))
+		for i, v := range vals {
+			if v == nil {
+				continue
+			}
+			d[i] = v.(int32)
+			setBit(i)
+		}
+	case FT_I64:
+		d := unsafe.Slice((*int64)(dst), len(vals))
+		for i, v := range vals {
+			if v == nil {
+				continue
+			}
+			d[i] = v.(int64)
+			setBit(i)
+		}
+	default:
+		panic("Unknown field type!")
+	}
+}
+
+// Close flushes any remaining buffered rows, sends DT_END, and reads the
+// server's final status.
+func (self *InsertOp) Close(ctx context.Context) error {
+	if err := self.Flush(ctx); err != nil {
+		return err
+	}
+
+	reset, err := self.client.withDeadline(ctx)
+	if err != nil {
+		return err
+	}
+	defer reset()
+
+	if err := self.client.WriteU32(DT_END); err != nil {
+		return err
+	}
+
+	dt, err := self.client.ReadU32()
+	if err != nil {
+		return err
+	}
+	if dt != DT_STATUS_CODE {
+		return &ProtocolError{Expected: DT_STATUS_CODE, Got: dt, Op: "CT_INSERT_POINTS"}
+	}
+	sc, err := self.client.ReadI32()
+	if err != nil {
+		return err
+	}
+	if sc != 0 {
+		return &TSDBError{Status: sc, Op: "CT_INSERT_POINTS"}
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package plugin
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func newTestInsertOp(t *testing.T, server *tls.Conn, client *TSDBClient, fieldType uint8) *InsertOp {
+	t.Helper()
+
+	schema := &Schema{
+		database:    "mydb",
+		measurement: "cpu",
+		fields:      []string{"value"},
+		fields_map: map[string]*SchemaField{
+			"value": {name: "value", field_type: fieldType},
+		},
+	}
+
+	go func() {
+		readU32(t, server) // CT_INSERT_POINTS
+		readU32(t, server)
+		readString(t, server) // database
+		readU32(t, server)
+		readString(t, server) // measurement
+		readU32(t, server)
+		readString(t, server) // series
+		readU32(t, server)
+		readString(t, server) // field list
+		readU32(t, server)    // DT_END
+
+		binary.Write(server, binary.LittleEndian, DT_READY_FOR_CHUNK)
+		binary.Write(server, binary.LittleEndian, uint32(1<<20))
+	}()
+
+	op, err := client.NewInsertOp(context.Background(), schema, "host=a", []string{"value"}, 0, 0)
+	if err != nil {
+		t.Fatalf("NewInsertOp: %v", err)
+	}
+	return op
+}
+
+// TestAppendRowCoercesLikeAppendFrame checks that AppendRow, given a
+// value whose Go type doesn't exactly match the schema's wire type (a
+// float64 for an FT_U32 field, as a caller piping numbers through `any`
+// would plausibly produce), is coerced rather than panicking at
+// Flush/Close time.
+func TestAppendRowCoercesLikeAppendFrame(t *testing.T) {
+	client, server := newTestTSDBClientPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	op := newTestInsertOp(t, server, client, FT_U32)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		readU32(t, server) // DT_CHUNK
+		readU32(t, server) // npoints
+		readU32(t, server) // bitmap_offset
+		data_len := readU32(t, server)
+		buf := make([]byte, data_len)
+		server.Read(buf)
+
+		readU32(t, server) // DT_END
+		writeStatus(t, server, 0)
+	}()
+
+	if err := op.AppendRow(context.Background(), 1000, []interface{}{float64(42)}); err != nil {
+		t.Fatalf("AppendRow with float64 for an FT_U32 field: %v", err)
+	}
+	if err := op.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	<-done
+}
+
+// TestAppendRowWireEncoding checks the DT_CHUNK layout AppendRow/Flush
+// emits (timestamps, a null bitmap per field, then packed values)
+// matches what RXChunk decodes.
+func TestAppendRowWireEncoding(t *testing.T) {
+	client, server := newTestTSDBClientPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	op := newTestInsertOp(t, server, client, FT_F64)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		readU32(t, server) // DT_CHUNK
+		npoints := readU32(t, server)
+		readU32(t, server) // bitmap_offset
+		data_len := readU32(t, server)
+
+		buf := make([]byte, data_len)
+		n := 0
+		for n < len(buf) {
+			m, err := server.Read(buf[n:])
+			if err != nil {
+				t.Fatalf("read chunk body: %v", err)
+			}
+			n += m
+		}
+
+		if npoints != 2 {
+			t.Errorf("npoints = %v, want 2", npoints)
+		}
+		ts0 := binary.LittleEndian.Uint64(buf[0:8])
+		ts1 := binary.LittleEndian.Uint64(buf[8:16])
+		if ts0 != 1000 || ts1 != 2000 {
+			t.Errorf("timestamps = %v, %v, want 1000, 2000", ts0, ts1)
+		}
+
+		bitmap := binary.LittleEndian.Uint64(buf[16:24])
+		if bitmap != 0b01 {
+			t.Errorf("bitmap = %b, want 0b01 (row 0 set, row 1 null)", bitmap)
+		}
+
+		v0 := math.Float64frombits(binary.LittleEndian.Uint64(buf[24:32]))
+		if v0 != 3.5 {
+			t.Errorf("value[0] = %v, want 3.5", v0)
+		}
+
+		readU32(t, server) // DT_END
+		writeStatus(t, server, 0)
+	}()
+
+	if err := op.AppendRow(context.Background(), 1000, []interface{}{3.5}); err != nil {
+		t.Fatalf("AppendRow: %v", err)
+	}
+	if err := op.AppendRow(context.Background(), 2000, []interface{}{nil}); err != nil {
+		t.Fatalf("AppendRow: %v", err)
+	}
+	if err := op.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	<-done
+}
@@ -0,0 +1,91 @@
+package plugin
+
+// lttbPoint is a plain (x, y) pair in float64 space, used internally by
+// the downsampler so it doesn't need to care whether the caller's time
+// axis is uint64 nanoseconds or something else.
+type lttbPoint struct {
+	x float64
+	y float64
+}
+
+// lttbDownsample implements Largest-Triangle-Three-Buckets downsampling
+// (Sveinn Steinarsson, 2013).  It divides pts (excluding the fixed first
+// and last points) into threshold-2 buckets; for each bucket it picks
+// the point forming the largest-area triangle with the previously
+// selected point and the average of the next bucket.  This preserves
+// visual peaks/valleys that naive mean/stride downsampling would erase,
+// in O(len(pts)) time.
+//
+// If threshold >= len(pts) or threshold < 3, pts is returned unchanged.
+func lttbDownsample(pts []lttbPoint, threshold int) []lttbPoint {
+	n := len(pts)
+	if threshold >= n || threshold < 3 {
+		return pts
+	}
+
+	sampled := make([]lttbPoint, 0, threshold)
+	sampled = append(sampled, pts[0])
+
+	// Bucket size for the data excluding the first and last points.
+	bucketSize := float64(n-2) / float64(threshold-2)
+
+	a := 0 // index of the previously-selected point within pts
+	for i := 0; i < threshold-2; i++ {
+		bucketStart := int(float64(i)*bucketSize) + 1
+		bucketEnd := int(float64(i+1)*bucketSize) + 1
+		if bucketEnd > n-1 {
+			bucketEnd = n - 1
+		}
+
+		// Average point of the *next* bucket.
+		nextStart := bucketEnd
+		nextEnd := int(float64(i+2)*bucketSize) + 1
+		if nextEnd > n {
+			nextEnd = n
+		}
+		if nextStart >= nextEnd {
+			nextEnd = nextStart + 1
+			if nextEnd > n {
+				nextEnd = n
+			}
+		}
+
+		var avgX, avgY float64
+		for j := nextStart; j < nextEnd; j++ {
+			avgX += pts[j].x
+			avgY += pts[j].y
+		}
+		count := float64(nextEnd - nextStart)
+		avgX /= count
+		avgY /= count
+
+		pa := pts[a]
+
+		bestIdx := bucketStart
+		bestArea := -1.0
+		for j := bucketStart; j < bucketEnd; j++ {
+			area := triangleArea(pa, pts[j], lttbPoint{avgX, avgY})
+			if area > bestArea {
+				bestArea = area
+				bestIdx = j
+			}
+		}
+
+		sampled = append(sampled, pts[bestIdx])
+		a = bestIdx
+	}
+
+	sampled = append(sampled, pts[n-1])
+	return sampled
+}
+
+// triangleArea returns twice the (unsigned) area of the triangle formed
+// by the three points; the factor of two doesn't matter since we only
+// compare magnitudes.
+func triangleArea(a, b, c lttbPoint) float64 {
+	area := (a.x-c.x)*(b.y-a.y) - (a.x-b.x)*(c.y-a.y)
+	if area < 0 {
+		return -area
+	}
+	return area
+}
@@ -0,0 +1,64 @@
+package plugin
+
+import "testing"
+
+func TestTriangleArea(t *testing.T) {
+	// A right triangle with legs 4 and 2 has area 4; triangleArea
+	// returns twice the area, so 8.
+	got := triangleArea(lttbPoint{0, 0}, lttbPoint{4, 0}, lttbPoint{4, 2})
+	if got != 8 {
+		t.Fatalf("triangleArea = %v, want 8", got)
+	}
+
+	// Collinear points form a degenerate triangle with zero area.
+	got = triangleArea(lttbPoint{0, 0}, lttbPoint{1, 1}, lttbPoint{2, 2})
+	if got != 0 {
+		t.Fatalf("triangleArea of collinear points = %v, want 0", got)
+	}
+}
+
+func TestLttbDownsamplePassesThroughBelowThreshold(t *testing.T) {
+	pts := []lttbPoint{{0, 0}, {1, 1}, {2, 2}}
+
+	if got := lttbDownsample(pts, 3); len(got) != len(pts) {
+		t.Fatalf("threshold == len(pts): got %v points, want %v unchanged", len(got), len(pts))
+	}
+	if got := lttbDownsample(pts, 2); len(got) != len(pts) {
+		t.Fatalf("threshold < 3: got %v points, want %v unchanged", len(got), len(pts))
+	}
+}
+
+func TestLttbDownsampleKeepsEndpointsAndPeak(t *testing.T) {
+	// A sharp spike in the middle of an otherwise flat series - LTTB
+	// should keep the first point, the last point, and the spike even
+	// though naive stride sampling would likely skip it.
+	pts := make([]lttbPoint, 0, 21)
+	for i := 0; i < 21; i++ {
+		y := 0.0
+		if i == 10 {
+			y = 100.0
+		}
+		pts = append(pts, lttbPoint{x: float64(i), y: y})
+	}
+
+	sampled := lttbDownsample(pts, 5)
+	if len(sampled) != 5 {
+		t.Fatalf("len(sampled) = %v, want 5", len(sampled))
+	}
+	if sampled[0] != pts[0] {
+		t.Fatalf("first point = %+v, want %+v", sampled[0], pts[0])
+	}
+	if sampled[len(sampled)-1] != pts[len(pts)-1] {
+		t.Fatalf("last point = %+v, want %+v", sampled[len(sampled)-1], pts[len(pts)-1])
+	}
+
+	sawPeak := false
+	for _, p := range sampled {
+		if p.y == 100.0 {
+			sawPeak = true
+		}
+	}
+	if !sawPeak {
+		t.Fatalf("sampled points %+v dropped the spike at x=10", sampled)
+	}
+}
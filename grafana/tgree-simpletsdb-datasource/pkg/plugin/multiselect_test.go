@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+// TestMultiFieldSelectDecodesEachFieldIndependently exercises
+// NewSelectOp/ReadChunk/AppendField with two fields of different wire
+// sizes (float64 and uint32) in a single chunk, checking that each
+// field's bitmap and packed data array are sliced out of the shared
+// chunk buffer at the right offsets and don't bleed into each other.
+func TestMultiFieldSelectDecodesEachFieldIndependently(t *testing.T) {
+	client, server := newTestTSDBClientPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	schema := &Schema{
+		database:    "mydb",
+		measurement: "cpu",
+		fields:      []string{"a", "b"},
+		fields_map: map[string]*SchemaField{
+			"a": {name: "a", field_type: FT_F64},
+			"b": {name: "b", field_type: FT_U32},
+		},
+	}
+
+	go func() {
+		readU32(t, server) // CT_SELECT_POINTS_LIMIT
+		readU32(t, server)
+		readString(t, server) // database
+		readU32(t, server)
+		readString(t, server) // measurement
+		readU32(t, server)
+		readString(t, server) // series
+		readU32(t, server)
+		readString(t, server) // field list
+		readU32(t, server)
+		readU64(t, server) // t0
+		readU32(t, server)
+		readU64(t, server) // t1
+		readU32(t, server)
+		readU64(t, server) // limit
+		readU32(t, server) // DT_END
+
+		npoints := uint32(2)
+		bitmapNslots := uint32(1)
+		dataLen := npoints*8 /* timestamps */ +
+			bitmapNslots*8 + npoints*8 /* field a: bitmap + f64 */ +
+			bitmapNslots*8 + npoints*4 /* field b: bitmap + u32 */
+
+		binary.Write(server, binary.LittleEndian, DT_CHUNK)
+		binary.Write(server, binary.LittleEndian, npoints)
+		binary.Write(server, binary.LittleEndian, uint32(0)) // bitmap_offset
+		binary.Write(server, binary.LittleEndian, dataLen)
+
+		binary.Write(server, binary.LittleEndian, uint64(1000))
+		binary.Write(server, binary.LittleEndian, uint64(2000))
+
+		// field "a": both points present.
+		binary.Write(server, binary.LittleEndian, uint64(0b11))
+		binary.Write(server, binary.LittleEndian, math.Float64bits(1.5))
+		binary.Write(server, binary.LittleEndian, math.Float64bits(2.5))
+
+		// field "b": only the second point present.
+		binary.Write(server, binary.LittleEndian, uint64(0b10))
+		binary.Write(server, binary.LittleEndian, uint32(0))
+		binary.Write(server, binary.LittleEndian, uint32(42))
+
+		binary.Write(server, binary.LittleEndian, DT_END)
+		writeStatus(t, server, 0)
+	}()
+
+	op, err := client.NewSelectOp(context.Background(), schema, "host=a", []string{"a", "b"}, 0, uint64(time.Hour), 0xFFFFFFFFFFFFFFFF)
+	if err != nil {
+		t.Fatalf("NewSelectOp: %v", err)
+	}
+
+	rxc, err := op.ReadChunk(context.Background())
+	if err != nil {
+		t.Fatalf("ReadChunk: %v", err)
+	}
+	if rxc == nil {
+		t.Fatal("ReadChunk returned no chunk")
+	}
+	defer rxc.Release()
+
+	ptrsA, allNilA := rxc.AppendField("a", []*float64{})
+	if allNilA {
+		t.Fatal("field a: all_nil = true, want false")
+	}
+	valsA := ptrsA.([]*float64)
+	if len(valsA) != 2 || *valsA[0] != 1.5 || *valsA[1] != 2.5 {
+		t.Fatalf("field a values = %v, want [1.5 2.5]", derefFloat64s(valsA))
+	}
+
+	ptrsB, allNilB := rxc.AppendField("b", []*uint32{})
+	if allNilB {
+		t.Fatal("field b: all_nil = true, want false")
+	}
+	valsB := ptrsB.([]*uint32)
+	if len(valsB) != 2 {
+		t.Fatalf("len(valsB) = %v, want 2", len(valsB))
+	}
+	if valsB[0] != nil {
+		t.Fatalf("field b[0] = %v, want nil (bit clear)", *valsB[0])
+	}
+	if valsB[1] == nil || *valsB[1] != 42 {
+		t.Fatalf("field b[1] = %v, want 42", valsB[1])
+	}
+}
+
+func derefFloat64s(ptrs []*float64) []float64 {
+	out := make([]float64, len(ptrs))
+	for i, p := range ptrs {
+		if p != nil {
+			out[i] = *p
+		}
+	}
+	return out
+}
@@ -0,0 +1,160 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	poolMaxIdle     = 8
+	poolIdleTimeout = 60 * time.Second
+)
+
+// pooledConn is a warm, authenticated TSDBClient sitting in a
+// TSDBClientPool waiting to be reused.
+type pooledConn struct {
+	tc       *TSDBClient
+	lastUsed time.Time
+}
+
+// TSDBClientPool keeps a bounded number of warm, authenticated
+// connections to a single (hostname, username) endpoint so that
+// QueryData doesn't pay a TLS handshake plus CT_AUTHENTICATE round trip
+// on every dashboard refresh.  Connections are health-checked with a
+// NOP before being handed out, and idle connections older than
+// poolIdleTimeout are evicted lazily when Get reaches them.
+type TSDBClientPool struct {
+	hostname string
+	username string
+	password string
+
+	mu    sync.Mutex
+	idle  []*pooledConn
+
+	opens     int64
+	reuses    int64
+	evictions int64
+}
+
+// NewTSDBClientPool creates a pool for the given endpoint.  No
+// connections are opened until the first Get.
+func NewTSDBClientPool(hostname string, username string, password string) *TSDBClientPool {
+	return &TSDBClientPool{
+		hostname: hostname,
+		username: username,
+		password: password,
+	}
+}
+
+// Get returns a healthy, authenticated TSDBClient, preferring a warm
+// connection from the idle list.  Callers must return the connection
+// with Put (or Discard, if it turned out to be broken).
+func (p *TSDBClientPool) Get() (*TSDBClient, error) {
+	for {
+		p.mu.Lock()
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		pc := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if time.Since(pc.lastUsed) > poolIdleTimeout {
+			pc.tc.Close()
+			atomic.AddInt64(&p.evictions, 1)
+			continue
+		}
+
+		if err := pc.tc.NOP(); err != nil {
+			pc.tc.Close()
+			atomic.AddInt64(&p.evictions, 1)
+			continue
+		}
+
+		atomic.AddInt64(&p.reuses, 1)
+		return pc.tc, nil
+	}
+
+	tc, err := NewTSDBClient(p.hostname, p.username, p.password)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&p.opens, 1)
+	return tc, nil
+}
+
+// Put returns a still-healthy connection to the pool for reuse.  If the
+// idle list is already at poolMaxIdle, the connection is closed instead.
+func (p *TSDBClientPool) Put(tc *TSDBClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle) >= poolMaxIdle {
+		tc.Close()
+		atomic.AddInt64(&p.evictions, 1)
+		return
+	}
+
+	p.idle = append(p.idle, &pooledConn{tc: tc, lastUsed: time.Now()})
+}
+
+// Discard closes a connection that turned out to be broken rather than
+// returning it to the idle list.
+func (p *TSDBClientPool) Discard(tc *TSDBClient) {
+	tc.Close()
+	atomic.AddInt64(&p.evictions, 1)
+}
+
+// Close tears down every idle connection in the pool.  Call this from
+// Dispose.
+func (p *TSDBClientPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, pc := range p.idle {
+		pc.tc.Close()
+	}
+	p.idle = nil
+}
+
+type poolStatsResponse struct {
+	Opens     int64 `json:"opens"`
+	Reuses    int64 `json:"reuses"`
+	Evictions int64 `json:"evictions"`
+	Idle      int   `json:"idle"`
+}
+
+func (d *Datasource) handlePoolStats(rw http.ResponseWriter, req *http.Request) {
+	if d.pool == nil {
+		http.Error(rw, "connection pool not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	d.pool.mu.Lock()
+	idle := len(d.pool.idle)
+	d.pool.mu.Unlock()
+
+	rsp := poolStatsResponse{
+		Opens:     atomic.LoadInt64(&d.pool.opens),
+		Reuses:    atomic.LoadInt64(&d.pool.reuses),
+		Evictions: atomic.LoadInt64(&d.pool.evictions),
+		Idle:      idle,
+	}
+
+	bytes, err := json.Marshal(rsp)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Add("Content-Type", "application/json")
+	_, err = rw.Write(bytes)
+	if err != nil {
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+}
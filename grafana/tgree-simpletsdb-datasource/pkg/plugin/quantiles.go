@@ -0,0 +1,341 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// tdigestCentroid is one weighted mean in a t-digest sketch, as streamed
+// back by the server for a single time bucket.  The server maintains the
+// sketch itself - bounding each centroid's weight to
+// 4*ceil(compression*k(q)) and re-merging to keep centroids ordered by
+// mean - so the client only ever needs to interpolate across an
+// already-merged, already-sorted set.
+type tdigestCentroid struct {
+	mean   float64
+	weight float64
+}
+
+// tdigestQuantile walks centroids (assumed sorted by mean) accumulating
+// weight, then linearly interpolates the mean at cumulative weight
+// q*totalWeight.  Returns false if centroids is empty.
+func tdigestQuantile(centroids []tdigestCentroid, q float64) (float64, bool) {
+	if len(centroids) == 0 {
+		return 0, false
+	}
+
+	total := 0.0
+	for _, c := range centroids {
+		total += c.weight
+	}
+	if total == 0 {
+		return 0, false
+	}
+
+	target := q * total
+	cum := 0.0
+	for i, c := range centroids {
+		next := cum + c.weight
+		if target <= next || i == len(centroids)-1 {
+			if i == 0 || target <= cum {
+				return c.mean, true
+			}
+			prev := centroids[i-1]
+			frac := (target - cum) / c.weight
+			return prev.mean + frac*(c.mean-prev.mean), true
+		}
+		cum = next
+	}
+
+	return centroids[len(centroids)-1].mean, true
+}
+
+type QuantilesOp struct {
+	client      *TSDBClient
+	database    string
+	measurement string
+	series      string
+	field       string
+	quantiles   []float64
+	compression uint32
+	t0          uint64
+	t1          uint64
+	window_ns   uint64
+	last_token  uint32
+}
+
+// NewQuantilesOp issues CT_QUANTILE_POINTS, asking the server to compute
+// a t-digest per time bucket and stream back approximate quantiles for
+// it - giving Grafana p50/p95/p99 panels without pulling raw points.
+func (self *TSDBClient) NewQuantilesOp(ctx context.Context, database string, measurement string, series string, field string, quantiles []float64, compression uint32, t0 uint64, t1 uint64, window_ns uint64) (*QuantilesOp, error) {
+	op := QuantilesOp{
+		client:      self,
+		database:    database,
+		measurement: measurement,
+		series:      series,
+		field:       field,
+		quantiles:   quantiles,
+		compression: compression,
+		t0:          t0,
+		t1:          t1,
+		window_ns:   window_ns,
+	}
+
+	reset, err := self.withDeadline(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reset()
+
+	err = self.WriteU32(CT_QUANTILE_POINTS)
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.WriteStringToken(DT_DATABASE, database)
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.WriteStringToken(DT_MEASUREMENT, measurement)
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.WriteStringToken(DT_SERIES, series)
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.WriteStringToken(DT_FIELD_LIST, field)
+	if err != nil {
+		return nil, err
+	}
+
+	qstrs := make([]string, len(quantiles))
+	for i, q := range quantiles {
+		qstrs[i] = strconv.FormatFloat(q, 'g', -1, 64)
+	}
+	err = self.WriteStringToken(DT_QUANTILE_LIST, strings.Join(qstrs, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.WriteU32(DT_COMPRESSION)
+	if err != nil {
+		return nil, err
+	}
+	err = self.WriteU32(compression)
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.WriteU64Token(DT_TIME_FIRST, t0)
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.WriteU64Token(DT_TIME_LAST, t1)
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.WriteU64Token(DT_WINDOW_NS, window_ns)
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.WriteU32(DT_END)
+	if err != nil {
+		return nil, err
+	}
+
+	op.last_token, err = self.ReadU32()
+	if err != nil {
+		return nil, err
+	}
+	if op.last_token == DT_STATUS_CODE {
+		sc, err := self.ReadI32()
+		if err != nil {
+			return nil, err
+		}
+		return nil, &TSDBError{Status: sc, Op: "CT_QUANTILE_POINTS"}
+	}
+
+	return &op, nil
+}
+
+// RXQuantilesChunk holds, per time bucket, the set of t-digest centroids
+// the server computed for that window.  Unlike RXSumsChunk/RXChunk, the
+// per-bucket payload is variable-length (a bucket's centroid count isn't
+// known up front), so this is parsed rather than aliased directly onto
+// the wire buffer.
+type RXQuantilesChunk struct {
+	op         *QuantilesOp
+	nbuckets   uint16
+	timestamps []uint64
+	centroids  [][]tdigestCentroid
+}
+
+func (self *QuantilesOp) ReadChunk(ctx context.Context) (*RXQuantilesChunk, error) {
+	reset, err := self.client.withDeadline(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reset()
+
+	if self.last_token == DT_END {
+		dt, err := self.client.ReadU32()
+		if err != nil {
+			return nil, err
+		}
+		if dt != DT_STATUS_CODE {
+			return nil, &ProtocolError{Expected: DT_STATUS_CODE, Got: dt, Op: "CT_QUANTILE_POINTS"}
+		}
+
+		sc, err := self.client.ReadI32()
+		if err != nil {
+			return nil, err
+		}
+		if sc != 0 {
+			return nil, &TSDBError{Status: sc, Op: "CT_QUANTILE_POINTS"}
+		}
+
+		return nil, nil
+	}
+
+	if self.last_token != DT_QUANTILES_CHUNK {
+		return nil, &ProtocolError{Expected: DT_QUANTILES_CHUNK, Got: self.last_token, Op: "CT_QUANTILE_POINTS"}
+	}
+	nbuckets, err := self.client.ReadU16()
+	if err != nil {
+		return nil, err
+	}
+
+	data_len, err := self.client.ReadU32()
+	if err != nil {
+		return nil, err
+	}
+	data := getChunkBuf(data_len)
+	defer putChunkBuf(data)
+	n, err := io.ReadFull(self.client.rd, data)
+	if err != nil {
+		return nil, err
+	}
+	if n != int(data_len) {
+		return nil, fmt.Errorf("TSDB protocol error: short chunk read (expected %v bytes, got %v) during CT_QUANTILE_POINTS", data_len, n)
+	}
+
+	self.last_token, err = self.client.ReadU32()
+	if err != nil {
+		return nil, err
+	}
+
+	// Unlike RXChunk/RXSumsChunk, the centroids below are parsed into
+	// freshly-allocated slices rather than aliased via unsafe.Slice (the
+	// per-bucket centroid count is variable, so there's no fixed layout
+	// to alias onto) - so data can be released the moment we're done
+	// parsing it instead of living as long as the caller holds the chunk.
+	return NewQuantilesChunk(self, nbuckets, data)
+}
+
+func NewQuantilesChunk(op *QuantilesOp, nbuckets uint16, data []byte) (*RXQuantilesChunk, error) {
+	r := bytes.NewReader(data)
+
+	timestamps := make([]uint64, nbuckets)
+	if err := binary.Read(r, binary.LittleEndian, timestamps); err != nil {
+		return nil, fmt.Errorf("TSDB protocol error: short quantiles timestamps during CT_QUANTILE_POINTS: %v", err)
+	}
+
+	centroids := make([][]tdigestCentroid, nbuckets)
+	for i := uint16(0); i < nbuckets; i++ {
+		var nCentroids uint16
+		if err := binary.Read(r, binary.LittleEndian, &nCentroids); err != nil {
+			return nil, fmt.Errorf("TSDB protocol error: short quantiles bucket header during CT_QUANTILE_POINTS: %v", err)
+		}
+
+		bucket := make([]tdigestCentroid, nCentroids)
+		for j := uint16(0); j < nCentroids; j++ {
+			if err := binary.Read(r, binary.LittleEndian, &bucket[j].mean); err != nil {
+				return nil, fmt.Errorf("TSDB protocol error: short centroid mean during CT_QUANTILE_POINTS: %v", err)
+			}
+			if err := binary.Read(r, binary.LittleEndian, &bucket[j].weight); err != nil {
+				return nil, fmt.Errorf("TSDB protocol error: short centroid weight during CT_QUANTILE_POINTS: %v", err)
+			}
+		}
+		centroids[i] = bucket
+	}
+
+	return &RXQuantilesChunk{
+		op:         op,
+		nbuckets:   nbuckets,
+		timestamps: timestamps,
+		centroids:  centroids,
+	}, nil
+}
+
+// AppendQuantile interpolates quantile q out of bucket's t-digest and
+// appends it - or nil, if the bucket saw no points - onto dst, which
+// must be a *<type> slice matching the field's schema type.
+func (self *RXQuantilesChunk) AppendQuantile(dst any, bucket uint16, q float64) any {
+	mean, ok := tdigestQuantile(self.centroids[bucket], q)
+
+	switch d := dst.(type) {
+	case []*uint8:
+		if !ok {
+			return append(d, nil)
+		}
+		v := uint8(mean)
+		return append(d, &v)
+
+	case []*uint32:
+		if !ok {
+			return append(d, nil)
+		}
+		v := uint32(mean)
+		return append(d, &v)
+
+	case []*uint64:
+		if !ok {
+			return append(d, nil)
+		}
+		v := uint64(mean)
+		return append(d, &v)
+
+	case []*float32:
+		if !ok {
+			return append(d, nil)
+		}
+		v := float32(mean)
+		return append(d, &v)
+
+	case []*float64:
+		if !ok {
+			return append(d, nil)
+		}
+		v := mean
+		return append(d, &v)
+
+	case []*int32:
+		if !ok {
+			return append(d, nil)
+		}
+		v := int32(mean)
+		return append(d, &v)
+
+	case []*int64:
+		if !ok {
+			return append(d, nil)
+		}
+		v := int64(mean)
+		return append(d, &v)
+
+	default:
+		panic("Unhandled type!")
+	}
+}
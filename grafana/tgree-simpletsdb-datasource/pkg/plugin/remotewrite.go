@@ -0,0 +1,173 @@
+package plugin
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// handleRemoteWrite accepts a Prometheus remote_write WriteRequest
+// (snappy-compressed protobuf, the same payload Prometheus and Telegraf
+// agents emit) and writes every sample straight into the TSDB over the
+// CT_WRITE_POINTS path, using the datasource's already-configured
+// database and auth.  Timeseries with no __name__ label are skipped.
+// This turns the plugin into a drop-in ingestion target without touching
+// the TSDB server at all.
+func (d *Datasource) handleRemoteWrite(rw http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" &&
+		req.Header.Get("X-Prometheus-Remote-Write-Version") != "" {
+		http.Error(rw, "unsupported remote_write version", http.StatusBadRequest)
+		return
+	}
+
+	compressed, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(rw, "snappy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var wr prompb.WriteRequest
+	if err := proto.Unmarshal(body, &wr); err != nil {
+		http.Error(rw, "protobuf: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	database := req.URL.Query().Get("database")
+	if database == "" {
+		database = d.database
+	}
+	if database == "" {
+		http.Error(rw, "no database configured or specified", http.StatusBadRequest)
+		return
+	}
+
+	tc, err := d.pool.Get()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+	ok := true
+	defer func() {
+		if ok {
+			d.pool.Put(tc)
+		} else {
+			d.pool.Discard(tc)
+		}
+	}()
+
+	for _, ts := range wr.Timeseries {
+		measurement, seriesKey := promLabelsToSeries(ts.Labels)
+		if measurement == "" {
+			continue
+		}
+
+		for _, s := range ts.Samples {
+			err = tc.WritePoint(database, measurement, seriesKey, "value", uint64(s.Timestamp)*1000000, s.Value)
+			if err != nil {
+				ok = false
+				http.Error(rw, err.Error(), http.StatusBadGateway)
+				return
+			}
+		}
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// promLabelsToSeries maps a Prometheus label set to (measurement,
+// series): __name__ becomes the measurement, and every other label is
+// rendered as "k=v", those strings sorted and joined as "k=v,k=v,..." to
+// become the series key, so that identical label sets always land in the
+// same series.
+func promLabelsToSeries(labels []prompb.Label) (string, string) {
+	measurement := ""
+	kvs := make([]string, 0, len(labels))
+	for _, l := range labels {
+		if l.Name == "__name__" {
+			measurement = l.Value
+			continue
+		}
+		kvs = append(kvs, l.Name+"="+l.Value)
+	}
+	sort.Strings(kvs)
+	return measurement, strings.Join(kvs, ",")
+}
+
+// WritePoint issues CT_WRITE_POINTS for a single (series, field, time,
+// value) sample.  It's a minimal synchronous write path; bulk/streaming
+// ingestion should use NewInsertOp instead.
+func (self *TSDBClient) WritePoint(database string, measurement string, series string, field string, t uint64, value float64) error {
+	err := self.WriteU32(CT_WRITE_POINTS)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteStringToken(DT_DATABASE, database)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteStringToken(DT_MEASUREMENT, measurement)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteStringToken(DT_SERIES, series)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteStringToken(DT_FIELD_LIST, field)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteU32(DT_POINT)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteU64(t)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteU64(math.Float64bits(value))
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteU32(DT_END)
+	if err != nil {
+		return err
+	}
+
+	dt, err := self.ReadU32()
+	if err != nil {
+		return err
+	}
+	if dt != DT_STATUS_CODE {
+		return &ProtocolError{Expected: DT_STATUS_CODE, Got: dt, Op: "CT_WRITE_POINTS"}
+	}
+	sc, err := self.ReadI32()
+	if err != nil {
+		return err
+	}
+	if sc != 0 {
+		return &TSDBError{Status: sc, Op: "CT_WRITE_POINTS"}
+	}
+
+	return nil
+}
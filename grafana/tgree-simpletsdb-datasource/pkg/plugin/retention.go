@@ -0,0 +1,299 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// RetentionPolicy mirrors InfluxDB's RetentionPolicyInfo: a duration
+// after which points expire, plus an optional shard-group duration
+// controlling how the server buckets storage internally.  A zero
+// Duration means "keep forever".
+type RetentionPolicy struct {
+	DurationNs      uint64 `json:"duration_ns"`
+	ShardDurationNs uint64 `json:"shard_duration_ns"`
+}
+
+// GetRetention issues CT_GET_RETENTION and returns the measurement's
+// current retention policy.
+func (self *TSDBClient) GetRetention(database string, measurement string) (*RetentionPolicy, error) {
+	err := self.WriteU32(CT_GET_RETENTION)
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.WriteStringToken(DT_DATABASE, database)
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.WriteStringToken(DT_MEASUREMENT, measurement)
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.WriteU32(DT_END)
+	if err != nil {
+		return nil, err
+	}
+
+	dt, err := self.ReadU32()
+	if err != nil {
+		return nil, err
+	}
+	if dt == DT_STATUS_CODE {
+		sc, err := self.ReadI32()
+		if err != nil {
+			return nil, err
+		}
+		return nil, &TSDBError{Status: sc, Op: "CT_GET_RETENTION"}
+	}
+	if dt != DT_DURATION_NS {
+		return nil, &ProtocolError{Expected: DT_DURATION_NS, Got: dt, Op: "CT_GET_RETENTION"}
+	}
+	durationNs, err := self.ReadU64()
+	if err != nil {
+		return nil, err
+	}
+
+	dt, err = self.ReadU32()
+	if err != nil {
+		return nil, err
+	}
+	if dt != DT_SHARD_DURATION_NS {
+		return nil, &ProtocolError{Expected: DT_SHARD_DURATION_NS, Got: dt, Op: "CT_GET_RETENTION"}
+	}
+	shardDurationNs, err := self.ReadU64()
+	if err != nil {
+		return nil, err
+	}
+
+	dt, err = self.ReadU32()
+	if err != nil {
+		return nil, err
+	}
+	if dt != DT_STATUS_CODE {
+		return nil, &ProtocolError{Expected: DT_STATUS_CODE, Got: dt, Op: "CT_GET_RETENTION"}
+	}
+	sc, err := self.ReadI32()
+	if err != nil {
+		return nil, err
+	}
+	if sc != 0 {
+		return nil, &TSDBError{Status: sc, Op: "CT_GET_RETENTION"}
+	}
+
+	return &RetentionPolicy{DurationNs: durationNs, ShardDurationNs: shardDurationNs}, nil
+}
+
+// SetRetention issues CT_SET_RETENTION to configure a measurement's
+// retention policy.
+func (self *TSDBClient) SetRetention(database string, measurement string, rp *RetentionPolicy) error {
+	err := self.WriteU32(CT_SET_RETENTION)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteStringToken(DT_DATABASE, database)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteStringToken(DT_MEASUREMENT, measurement)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteU64Token(DT_DURATION_NS, rp.DurationNs)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteU64Token(DT_SHARD_DURATION_NS, rp.ShardDurationNs)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteU32(DT_END)
+	if err != nil {
+		return err
+	}
+
+	dt, err := self.ReadU32()
+	if err != nil {
+		return err
+	}
+	if dt != DT_STATUS_CODE {
+		return &ProtocolError{Expected: DT_STATUS_CODE, Got: dt, Op: "CT_SET_RETENTION"}
+	}
+	sc, err := self.ReadI32()
+	if err != nil {
+		return err
+	}
+	if sc != 0 {
+		return &TSDBError{Status: sc, Op: "CT_SET_RETENTION"}
+	}
+
+	return nil
+}
+
+// DeletePoints issues CT_DELETE_POINTS to remove every point in
+// [t0, t1) for a series.
+func (self *TSDBClient) DeletePoints(database string, measurement string, series string, t0 uint64, t1 uint64) error {
+	err := self.WriteU32(CT_DELETE_POINTS)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteStringToken(DT_DATABASE, database)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteStringToken(DT_MEASUREMENT, measurement)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteStringToken(DT_SERIES, series)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteU64Token(DT_TIME_FIRST, t0)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteU64Token(DT_TIME_LAST, t1)
+	if err != nil {
+		return err
+	}
+
+	err = self.WriteU32(DT_END)
+	if err != nil {
+		return err
+	}
+
+	dt, err := self.ReadU32()
+	if err != nil {
+		return err
+	}
+	if dt != DT_STATUS_CODE {
+		return &ProtocolError{Expected: DT_STATUS_CODE, Got: dt, Op: "CT_DELETE_POINTS"}
+	}
+	sc, err := self.ReadI32()
+	if err != nil {
+		return err
+	}
+	if sc != 0 {
+		return &TSDBError{Status: sc, Op: "CT_DELETE_POINTS"}
+	}
+
+	return nil
+}
+
+func (d *Datasource) handleRetention(rw http.ResponseWriter, req *http.Request) {
+	database := req.URL.Query().Get("database")
+	measurement := req.URL.Query().Get("measurement")
+	if database == "" || measurement == "" {
+		http.Error(rw, "database and measurement are required", http.StatusBadRequest)
+		return
+	}
+
+	tc, err := d.pool.Get()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+	ok := true
+	defer func() {
+		if ok {
+			d.pool.Put(tc)
+		} else {
+			d.pool.Discard(tc)
+		}
+	}()
+
+	switch req.Method {
+	case http.MethodGet:
+		rp, err := tc.GetRetention(database, measurement)
+		if err != nil {
+			ok = false
+			http.Error(rw, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		bytes, err := json.Marshal(rp)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		rw.Write(bytes)
+
+	case http.MethodPut:
+		var rp RetentionPolicy
+		if err := json.NewDecoder(req.Body).Decode(&rp); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := tc.SetRetention(database, measurement, &rp); err != nil {
+			ok = false
+			http.Error(rw, err.Error(), http.StatusBadGateway)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type deleteRequest struct {
+	Database    string `json:"database"`
+	Measurement string `json:"measurement"`
+	Series      string `json:"series"`
+	T0          uint64 `json:"t0"`
+	T1          uint64 `json:"t1"`
+}
+
+func (d *Datasource) handleDelete(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var dr deleteRequest
+	if err := json.NewDecoder(req.Body).Decode(&dr); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if dr.Database == "" || dr.Measurement == "" || dr.Series == "" {
+		http.Error(rw, "database, measurement and series are required", http.StatusBadRequest)
+		return
+	}
+
+	tc, err := d.pool.Get()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+	ok := true
+	defer func() {
+		if ok {
+			d.pool.Put(tc)
+		} else {
+			d.pool.Discard(tc)
+		}
+	}()
+
+	if err := tc.DeletePoints(dr.Database, dr.Measurement, dr.Series, dr.T0, dr.T1); err != nil {
+		ok = false
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,231 @@
+package plugin
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestTSDBClientPair sets up a real in-memory TLS connection (over
+// net.Pipe, with an ephemeral self-signed cert) so the tests below can
+// exercise the actual wire encoding/decoding logic in TSDBClient rather
+// than mocking it away.
+func newTestTSDBClientPair(t *testing.T) (*TSDBClient, *tls.Conn) {
+	t.Helper()
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}
+
+	clientConn, serverConn := net.Pipe()
+	serverTLS := tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{cert}})
+	clientTLS := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+
+	done := make(chan error, 1)
+	go func() { done <- serverTLS.Handshake() }()
+	if err := clientTLS.Handshake(); err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server handshake: %v", err)
+	}
+
+	return newTSDBClientFromConn(clientTLS), serverTLS
+}
+
+func readU32(t *testing.T, conn *tls.Conn) uint32 {
+	t.Helper()
+	var v uint32
+	if err := binary.Read(conn, binary.LittleEndian, &v); err != nil {
+		t.Fatalf("readU32: %v", err)
+	}
+	return v
+}
+
+func readU64(t *testing.T, conn *tls.Conn) uint64 {
+	t.Helper()
+	var v uint64
+	if err := binary.Read(conn, binary.LittleEndian, &v); err != nil {
+		t.Fatalf("readU64: %v", err)
+	}
+	return v
+}
+
+func readString(t *testing.T, conn *tls.Conn) string {
+	t.Helper()
+	var size uint16
+	if err := binary.Read(conn, binary.LittleEndian, &size); err != nil {
+		t.Fatalf("readString size: %v", err)
+	}
+	buf := make([]byte, size)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatalf("readString data: %v", err)
+	}
+	return string(buf)
+}
+
+func writeStatus(t *testing.T, conn *tls.Conn, sc int32) {
+	t.Helper()
+	if err := binary.Write(conn, binary.LittleEndian, DT_STATUS_CODE); err != nil {
+		t.Fatalf("write DT_STATUS_CODE: %v", err)
+	}
+	if err := binary.Write(conn, binary.LittleEndian, sc); err != nil {
+		t.Fatalf("write status: %v", err)
+	}
+}
+
+func TestGetRetentionWireEncoding(t *testing.T) {
+	client, server := newTestTSDBClientPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		if got := readU32(t, server); got != CT_GET_RETENTION {
+			t.Errorf("expected CT_GET_RETENTION, got 0x%08X", got)
+		}
+		if got := readU32(t, server); got != DT_DATABASE {
+			t.Errorf("expected DT_DATABASE, got 0x%08X", got)
+		}
+		if got := readString(t, server); got != "mydb" {
+			t.Errorf("expected database mydb, got %q", got)
+		}
+		if got := readU32(t, server); got != DT_MEASUREMENT {
+			t.Errorf("expected DT_MEASUREMENT, got 0x%08X", got)
+		}
+		if got := readString(t, server); got != "cpu" {
+			t.Errorf("expected measurement cpu, got %q", got)
+		}
+		if got := readU32(t, server); got != DT_END {
+			t.Errorf("expected DT_END, got 0x%08X", got)
+		}
+
+		binary.Write(server, binary.LittleEndian, DT_DURATION_NS)
+		binary.Write(server, binary.LittleEndian, uint64(3600000000000))
+		binary.Write(server, binary.LittleEndian, DT_SHARD_DURATION_NS)
+		binary.Write(server, binary.LittleEndian, uint64(600000000000))
+		writeStatus(t, server, 0)
+	}()
+
+	rp, err := client.GetRetention("mydb", "cpu")
+	if err != nil {
+		t.Fatalf("GetRetention: %v", err)
+	}
+	if rp.DurationNs != 3600000000000 || rp.ShardDurationNs != 600000000000 {
+		t.Fatalf("unexpected retention policy: %+v", rp)
+	}
+}
+
+func TestSetRetentionWireEncoding(t *testing.T) {
+	client, server := newTestTSDBClientPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		if got := readU32(t, server); got != CT_SET_RETENTION {
+			t.Errorf("expected CT_SET_RETENTION, got 0x%08X", got)
+		}
+		readU32(t, server)
+		readString(t, server) // database
+		readU32(t, server)
+		readString(t, server) // measurement
+		if got := readU32(t, server); got != DT_DURATION_NS {
+			t.Errorf("expected DT_DURATION_NS, got 0x%08X", got)
+		}
+		if got := readU64(t, server); got != 42 {
+			t.Errorf("expected duration 42, got %v", got)
+		}
+		if got := readU32(t, server); got != DT_SHARD_DURATION_NS {
+			t.Errorf("expected DT_SHARD_DURATION_NS, got 0x%08X", got)
+		}
+		if got := readU64(t, server); got != 7 {
+			t.Errorf("expected shard duration 7, got %v", got)
+		}
+		readU32(t, server) // DT_END
+		writeStatus(t, server, 0)
+	}()
+
+	err := client.SetRetention("mydb", "cpu", &RetentionPolicy{DurationNs: 42, ShardDurationNs: 7})
+	if err != nil {
+		t.Fatalf("SetRetention: %v", err)
+	}
+}
+
+func TestDeletePointsWireEncoding(t *testing.T) {
+	client, server := newTestTSDBClientPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		if got := readU32(t, server); got != CT_DELETE_POINTS {
+			t.Errorf("expected CT_DELETE_POINTS, got 0x%08X", got)
+		}
+		readU32(t, server)
+		readString(t, server) // database
+		readU32(t, server)
+		readString(t, server) // measurement
+		readU32(t, server)
+		readString(t, server) // series
+		if got := readU32(t, server); got != DT_TIME_FIRST {
+			t.Errorf("expected DT_TIME_FIRST, got 0x%08X", got)
+		}
+		readU64(t, server)
+		if got := readU32(t, server); got != DT_TIME_LAST {
+			t.Errorf("expected DT_TIME_LAST, got 0x%08X", got)
+		}
+		readU64(t, server)
+		readU32(t, server) // DT_END
+		writeStatus(t, server, 0)
+	}()
+
+	err := client.DeletePoints("mydb", "cpu", "host=a", 0, 100)
+	if err != nil {
+		t.Fatalf("DeletePoints: %v", err)
+	}
+}
+
+func TestGetRetentionErrorStatus(t *testing.T) {
+	client, server := newTestTSDBClientPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		readU32(t, server)
+		readU32(t, server)
+		readString(t, server)
+		readU32(t, server)
+		readString(t, server)
+		readU32(t, server) // DT_END
+		writeStatus(t, server, 12)
+	}()
+
+	_, err := client.GetRetention("mydb", "nosuch")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	tsdbErr, ok := err.(*TSDBError)
+	if !ok {
+		t.Fatalf("expected *TSDBError, got %T: %v", err, err)
+	}
+	if tsdbErr.Status != 12 {
+		t.Fatalf("expected status 12, got %v", tsdbErr.Status)
+	}
+}
@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// fakeSelectServer drives one GetSchema + CT_SELECT_POINTS_LIMIT round
+// trip over server, replying with a single float64 field and one
+// DT_CHUNK carrying the given values (all non-null).
+func fakeSelectServer(t *testing.T, server *tls.Conn, values []float64) {
+	t.Helper()
+
+	readU32(t, server) // CT_GET_SCHEMA
+	readU32(t, server)
+	readString(t, server) // database
+	readU32(t, server)
+	readString(t, server) // measurement
+	readU32(t, server)     // DT_END
+
+	binary.Write(server, binary.LittleEndian, DT_FIELD_TYPE)
+	binary.Write(server, binary.LittleEndian, uint32(FT_F64))
+	binary.Write(server, binary.LittleEndian, DT_FIELD_NAME)
+	binary.Write(server, binary.LittleEndian, uint16(len("value")))
+	server.Write([]byte("value"))
+	writeStatus(t, server, 0)
+
+	readU32(t, server) // CT_SELECT_POINTS_LIMIT
+	readU32(t, server)
+	readString(t, server) // database
+	readU32(t, server)
+	readString(t, server) // measurement
+	readU32(t, server)
+	readString(t, server) // series
+	readU32(t, server)
+	readString(t, server) // field list
+	readU32(t, server)
+	readU64(t, server) // t0
+	readU32(t, server)
+	readU64(t, server) // t1
+	readU32(t, server)
+	readU64(t, server) // limit
+	readU32(t, server) // DT_END
+
+	npoints := uint32(len(values))
+	bitmap_nslots := (npoints + 63) / 64
+	data_len := npoints*8 + bitmap_nslots*8 + npoints*8
+
+	binary.Write(server, binary.LittleEndian, DT_CHUNK)
+	binary.Write(server, binary.LittleEndian, npoints)
+	binary.Write(server, binary.LittleEndian, uint32(0)) // bitmap_offset
+	binary.Write(server, binary.LittleEndian, data_len)
+
+	for i := uint32(0); i < npoints; i++ {
+		binary.Write(server, binary.LittleEndian, uint64(i))
+	}
+	bitmap := make([]uint64, bitmap_nslots)
+	for i := uint32(0); i < npoints; i++ {
+		bitmap[i/64] |= 1 << (i % 64)
+	}
+	binary.Write(server, binary.LittleEndian, bitmap)
+	for _, v := range values {
+		binary.Write(server, binary.LittleEndian, math.Float64bits(v))
+	}
+
+	binary.Write(server, binary.LittleEndian, DT_END)
+	writeStatus(t, server, 0)
+}
+
+// TestQuerySelectReleasesChunkBufWithoutCorruption runs two back-to-back
+// querySelect calls so the first one's Release()d buffer is likely to
+// come straight back out of chunkBufPool for the second query's own
+// Get - and checks that the first query's already-returned Frame still
+// holds its original values afterwards.  Before AppendField was fixed to
+// copy values instead of aliasing rxc.data, this failed because the pool
+// handed the recycled buffer straight back with the second query's bytes
+// in it while the first Frame still pointed into it.
+func TestQuerySelectReleasesChunkBufWithoutCorruption(t *testing.T) {
+	d := &Datasource{}
+
+	client1, server1 := newTestTSDBClientPair(t)
+	defer client1.Close()
+	defer server1.Close()
+	go fakeSelectServer(t, server1, []float64{1, 2, 3})
+
+	frame1, err := d.querySelect(context.Background(), client1, "mydb", "cpu", "host=a", "value", "value", 0, 1000)
+	if err != nil {
+		t.Fatalf("querySelect #1: %v", err)
+	}
+
+	client2, server2 := newTestTSDBClientPair(t)
+	defer client2.Close()
+	defer server2.Close()
+	go fakeSelectServer(t, server2, []float64{999, 998, 997})
+
+	frame2, err := d.querySelect(context.Background(), client2, "mydb", "cpu", "host=b", "value", "value", 0, 1000)
+	if err != nil {
+		t.Fatalf("querySelect #2: %v", err)
+	}
+
+	valueField1, _ := frame1.FieldByName("value")
+	for i, want := range []float64{1, 2, 3} {
+		got := *(valueField1.At(i).(*float64))
+		if got != want {
+			t.Fatalf("frame1[%d] = %v, want %v (chunk buffer reuse corrupted an already-returned Frame)", i, got, want)
+		}
+	}
+
+	valueField2, _ := frame2.FieldByName("value")
+	for i, want := range []float64{999, 998, 997} {
+		got := *(valueField2.At(i).(*float64))
+		if got != want {
+			t.Fatalf("frame2[%d] = %v, want %v", i, got, want)
+		}
+	}
+}
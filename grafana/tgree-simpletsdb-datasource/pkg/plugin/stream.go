@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Make sure Datasource implements the streaming interfaces.
+var (
+	_ backend.StreamHandler = (*Datasource)(nil)
+)
+
+const streamReconnectBackoff = 2 * time.Second
+
+// SubscribeStream is called when a panel first subscribes to a channel
+// for a Live query.  We just validate the path; the actual work happens
+// in RunStream.
+func (d *Datasource) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	var spm streamPathModel
+	if err := json.Unmarshal([]byte(req.Path), &spm); err != nil {
+		return &backend.SubscribeStreamResponse{
+			Status: backend.SubscribeStreamStatusNotFound,
+		}, nil
+	}
+
+	return &backend.SubscribeStreamResponse{
+		Status: backend.SubscribeStreamStatusOK,
+	}, nil
+}
+
+// streamPathModel is the JSON-encoded path used to identify a live
+// subscription; it's built by query() out of the queryModel.
+type streamPathModel struct {
+	Database    string `json:"database"`
+	Measurement string `json:"measurement"`
+	Series      string `json:"series"`
+	Field       string `json:"field"`
+	Since       uint64 `json:"since"`
+}
+
+// RunStream pulls points from TSDBClient.Subscribe and republishes them
+// to Grafana on the channel until the context is cancelled.  It owns its
+// own connection (separate from the query pool) since it's long-lived.
+func (d *Datasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	var spm streamPathModel
+	if err := json.Unmarshal([]byte(req.Path), &spm); err != nil {
+		return fmt.Errorf("bad stream path %q: %w", req.Path, err)
+	}
+
+	for {
+		if err := d.runStreamOnce(ctx, &spm, sender); err != nil {
+			backend.Logger.Warn("Stream connection lost, reconnecting", "path", req.Path, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(streamReconnectBackoff):
+		}
+	}
+}
+
+func (d *Datasource) runStreamOnce(ctx context.Context, spm *streamPathModel, sender *backend.StreamSender) error {
+	tc, err := NewTSDBClient(d.hostname, d.username, d.password)
+	if err != nil {
+		return err
+	}
+	defer tc.Close()
+
+	points, errc, err := tc.Subscribe(ctx, spm.Database, spm.Measurement, spm.Series, spm.Field, spm.Since)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case err := <-errc:
+			return err
+
+		case pt, ok := <-points:
+			if !ok {
+				return nil
+			}
+			frame := data.NewFrame("stream",
+				data.NewField("time", nil, []time.Time{time.Unix(0, int64(pt.time))}),
+				data.NewField("value", nil, []float64{pt.value}),
+			)
+			if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+				return err
+			}
+			// Advance since past this point so a reconnect resumes the
+			// tail instead of re-subscribing from the original time and
+			// replaying everything already delivered.
+			spm.Since = pt.time + 1
+		}
+	}
+}
+
+// PublishStream is called when a client tries to publish to a channel;
+// this datasource is read-only over streaming so we reject it.
+func (d *Datasource) PublishStream(ctx context.Context, req *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{
+		Status: backend.PublishStreamStatusPermissionDenied,
+	}, nil
+}
@@ -0,0 +1,45 @@
+package plugin
+
+import "testing"
+
+func TestTdigestQuantileEmpty(t *testing.T) {
+	if _, ok := tdigestQuantile(nil, 0.5); ok {
+		t.Fatal("expected ok=false for empty centroids")
+	}
+	if _, ok := tdigestQuantile([]tdigestCentroid{{mean: 1, weight: 0}}, 0.5); ok {
+		t.Fatal("expected ok=false when total weight is zero")
+	}
+}
+
+func TestTdigestQuantileSingleCentroid(t *testing.T) {
+	centroids := []tdigestCentroid{{mean: 5, weight: 10}}
+	for _, q := range []float64{0, 0.5, 1} {
+		got, ok := tdigestQuantile(centroids, q)
+		if !ok || got != 5 {
+			t.Fatalf("q=%v: got (%v, %v), want (5, true)", q, got, ok)
+		}
+	}
+}
+
+func TestTdigestQuantileInterpolatesBetweenCentroids(t *testing.T) {
+	centroids := []tdigestCentroid{{mean: 0, weight: 1}, {mean: 10, weight: 1}}
+
+	cases := []struct {
+		q    float64
+		want float64
+	}{
+		{0.25, 0}, // target falls within the first centroid's own weight
+		{0.5, 0},  // boundary between the two centroids
+		{0.75, 5}, // halfway through the second centroid's weight
+		{1.0, 10}, // top of the second centroid's weight
+	}
+	for _, c := range cases {
+		got, ok := tdigestQuantile(centroids, c.q)
+		if !ok {
+			t.Fatalf("q=%v: ok=false", c.q)
+		}
+		if got != c.want {
+			t.Fatalf("q=%v: got %v, want %v", c.q, got, c.want)
+		}
+	}
+}
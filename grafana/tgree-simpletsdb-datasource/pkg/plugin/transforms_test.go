@@ -0,0 +1,126 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func f64p(v float64) *float64 { return &v }
+
+func timesAt(secs ...int) []time.Time {
+	out := make([]time.Time, len(secs))
+	for i, s := range secs {
+		out[i] = time.Unix(int64(s), 0)
+	}
+	return out
+}
+
+func assertFloats(t *testing.T, field *data.Field, want []*float64) {
+	t.Helper()
+	if field.Len() != len(want) {
+		t.Fatalf("len = %v, want %v", field.Len(), len(want))
+	}
+	for i, w := range want {
+		v, ok := field.ConcreteAt(i)
+		if w == nil {
+			if ok {
+				t.Fatalf("index %v: got %v, want nil", i, v)
+			}
+			continue
+		}
+		if !ok {
+			t.Fatalf("index %v: got nil, want %v", i, *w)
+		}
+		if v.(float64) != *w {
+			t.Fatalf("index %v: got %v, want %v", i, v, *w)
+		}
+	}
+}
+
+func TestTransformFillNullPrevious(t *testing.T) {
+	frame := data.NewFrame("f",
+		data.NewField("time", nil, timesAt(0, 1, 2, 3)),
+		data.NewField("value", nil, []*float64{f64p(1), nil, nil, f64p(4)}),
+	)
+
+	TransformFillNull(frame, FillPrevious, 0)
+
+	field, _ := frame.FieldByName("value")
+	assertFloats(t, field, []*float64{f64p(1), f64p(1), f64p(1), f64p(4)})
+}
+
+func TestTransformFillNullLinear(t *testing.T) {
+	frame := data.NewFrame("f",
+		data.NewField("time", nil, timesAt(0, 10, 20, 30)),
+		data.NewField("value", nil, []*float64{f64p(0), nil, nil, f64p(30)}),
+	)
+
+	TransformFillNull(frame, FillLinear, 0)
+
+	field, _ := frame.FieldByName("value")
+	assertFloats(t, field, []*float64{f64p(0), f64p(10), f64p(20), f64p(30)})
+}
+
+func TestTransformFillNullConstant(t *testing.T) {
+	frame := data.NewFrame("f",
+		data.NewField("time", nil, timesAt(0, 1, 2)),
+		data.NewField("value", nil, []*float64{nil, f64p(5), nil}),
+	)
+
+	TransformFillNull(frame, FillConstant, 9)
+
+	field, _ := frame.FieldByName("value")
+	assertFloats(t, field, []*float64{f64p(9), f64p(5), f64p(9)})
+}
+
+func TestTransformFillNullAllNullFieldIsLeftAlone(t *testing.T) {
+	frame := data.NewFrame("f",
+		data.NewField("time", nil, timesAt(0, 1)),
+		data.NewField("value", nil, []*float64{nil, nil}),
+	)
+
+	TransformFillNull(frame, FillConstant, 9)
+
+	field, _ := frame.FieldByName("value")
+	assertFloats(t, field, []*float64{nil, nil})
+}
+
+func TestTransformMovingAverage(t *testing.T) {
+	frame := data.NewFrame("f",
+		data.NewField("time", nil, timesAt(0, 1, 2, 3)),
+		data.NewField("value", nil, []*float64{f64p(1), f64p(2), f64p(3), f64p(4)}),
+	)
+
+	TransformMovingAverage(frame, 2)
+
+	field, _ := frame.FieldByName("value")
+	assertFloats(t, field, []*float64{f64p(1), f64p(1.5), f64p(2.5), f64p(3.5)})
+}
+
+func TestTransformMovingAverageIgnoresNullsInWindow(t *testing.T) {
+	frame := data.NewFrame("f",
+		data.NewField("time", nil, timesAt(0, 1, 2)),
+		data.NewField("value", nil, []*float64{f64p(1), nil, f64p(3)}),
+	)
+
+	TransformMovingAverage(frame, 2)
+
+	field, _ := frame.FieldByName("value")
+	// window=2: i=0 -> {1}; i=1 -> {1,nil} -> just 1; i=2 -> {nil,3} -> just 3.
+	assertFloats(t, field, []*float64{f64p(1), f64p(1), f64p(3)})
+}
+
+func TestTransformRate(t *testing.T) {
+	frame := data.NewFrame("f",
+		data.NewField("time", nil, timesAt(0, 1, 2, 3)),
+		data.NewField("value", nil, []*float64{f64p(10), f64p(15), f64p(13), f64p(20)}),
+	)
+
+	TransformRate(frame)
+
+	field, _ := frame.FieldByName("value")
+	// i=0 always nil; i=1: (15-10)/1s=5; i=2: delta -2 < 0 (counter reset) -> nil; i=3: (20-13)/1s=7.
+	assertFloats(t, field, []*float64{nil, f64p(5), nil, f64p(7)})
+}